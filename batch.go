@@ -0,0 +1,142 @@
+package lz4
+
+// batch.go compresses and decompresses many buffers with a single call into
+// the lz4 library, since for small buffers the cost of calling C dominates.
+// The buffers are packed into one source and one destination buffer, so that
+// C only sees offsets into them.
+
+// #include "src/lz4.h"
+//
+// typedef struct {
+//     size_t srcOffset;
+//     size_t dstOffset;
+//     int srcSize;
+//     int dstCapacity;
+//     int result;
+// } LZ4_batchEntry;
+//
+// /* LZ4_compress_fast clears its 16KB hash table for every input, which
+//  * costs more than compressing a small one.  Instead, the entries share a
+//  * stream, which forgets each entry after compressing it by setting an
+//  * empty dictionary: matches that start before the next entry are then
+//  * rejected, so that each entry is compressed into an independent block. */
+// static void LZ4_compress_batch(const char* src, char* dst, LZ4_batchEntry* entries, int n) {
+//     LZ4_stream_t stream;
+//     char noDict;
+//     int i;
+//     LZ4_resetStream(&stream);
+//     for (i = 0; i < n; i++) {
+//         LZ4_batchEntry* e = &entries[i];
+//         e->result = LZ4_compress_fast_continue(&stream, src + e->srcOffset, dst + e->dstOffset, e->srcSize, e->dstCapacity, 1);
+//         LZ4_saveDict(&stream, &noDict, 0);
+//     }
+// }
+//
+// static void LZ4_decompress_batch(const char* src, char* dst, LZ4_batchEntry* entries, int n) {
+//     int i;
+//     for (i = 0; i < n; i++) {
+//         LZ4_batchEntry* e = &entries[i];
+//         /* lz4 reads the first byte of the input even if there is none. */
+//         e->result = e->srcSize == 0 ? -1 : LZ4_decompress_safe(src + e->srcOffset, dst + e->dstOffset, e->srcSize, e->dstCapacity);
+//     }
+// }
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// batch holds the packed buffers of a batch.
+type batch struct {
+	entries []C.LZ4_batchEntry
+	src     []byte
+	dst     []byte
+}
+
+var batchPool = sync.Pool{New: func() interface{} { return new(batch) }}
+
+// pack copies srcs into b.src, and reserves room for dsts in b.dst.
+func (b *batch) pack(dsts, srcs [][]byte) error {
+	if len(dsts) != len(srcs) {
+		return errors.New("lz4: dsts and srcs have different lengths")
+	}
+	b.entries = b.entries[:0]
+	b.src = b.src[:0]
+	dstSize := 0
+	for i := range srcs {
+		b.entries = append(b.entries, C.LZ4_batchEntry{
+			srcOffset:   C.size_t(len(b.src)),
+			dstOffset:   C.size_t(dstSize),
+			srcSize:     clen(srcs[i]),
+			dstCapacity: clen(dsts[i]),
+		})
+		b.src = append(b.src, srcs[i]...)
+		dstSize += len(dsts[i])
+	}
+	if cap(b.dst) < dstSize {
+		b.dst = make([]byte, dstSize)
+	}
+	b.dst = b.dst[:dstSize]
+	return nil
+}
+
+// result copies the output of entry i into dst, and returns its size, or a
+// negative value on failure.
+func (b *batch) result(i int, dst []byte) int {
+	e := &b.entries[i]
+	if e.result > 0 {
+		copy(dst, b.dst[e.dstOffset:e.dstOffset+C.size_t(e.result)])
+	}
+	return int(e.result)
+}
+
+// CompressBatch compresses every srcs[i] into dsts[i] like Compress, but
+// with a single call into the lz4 library, which saves the cost of the call
+// and of resetting the compression state for each buffer when there are
+// many small ones.  It returns the compressed size of each buffer.  If any
+// fails, its size is 0, the others are still compressed, and the error
+// tells which was the first to fail.
+func CompressBatch(dsts, srcs [][]byte) ([]int, error) {
+	b := batchPool.Get().(*batch)
+	defer batchPool.Put(b)
+	if err := b.pack(dsts, srcs); err != nil || len(srcs) == 0 {
+		return nil, err
+	}
+	C.LZ4_compress_batch(p(b.src), p(b.dst), &b.entries[0], C.int(len(b.entries)))
+
+	var err error
+	sizes := make([]int, len(srcs))
+	for i := range sizes {
+		sizes[i] = b.result(i, dsts[i])
+		if sizes[i] == 0 && err == nil {
+			err = fmt.Errorf("lz4: batch entry %d: %w", i, compressError(srcs[i]))
+		}
+	}
+	return sizes, err
+}
+
+// UncompressBatch decompresses every srcs[i] into dsts[i] like Uncompress,
+// but with a single call into the lz4 library.  It returns the decompressed
+// size of each buffer.  If any fails, its size is 0, the others are still
+// decompressed, and the error tells which was the first to fail.
+func UncompressBatch(dsts, srcs [][]byte) ([]int, error) {
+	b := batchPool.Get().(*batch)
+	defer batchPool.Put(b)
+	if err := b.pack(dsts, srcs); err != nil || len(srcs) == 0 {
+		return nil, err
+	}
+	C.LZ4_decompress_batch(p(b.src), p(b.dst), &b.entries[0], C.int(len(b.entries)))
+
+	var err error
+	sizes := make([]int, len(srcs))
+	for i := range sizes {
+		if n := b.result(i, dsts[i]); n >= 0 {
+			sizes[i] = n
+		} else if err == nil {
+			err = fmt.Errorf("lz4: batch entry %d: %w", i, uncompressError(srcs[i], 0, len(dsts[i])))
+		}
+	}
+	return sizes, err
+}
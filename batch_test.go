@@ -0,0 +1,169 @@
+package lz4
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"testing/quick"
+)
+
+func TestBatch(t *testing.T) {
+	srcs := [][]byte{nil, plaintext0, []byte("a")}
+	for i := 0; i < 100; i++ {
+		srcs = append(srcs, jsonEvent(i))
+	}
+	dsts := make([][]byte, len(srcs))
+	for i := range srcs {
+		dsts[i] = make([]byte, CompressBound(srcs[i]))
+	}
+	sizes, err := CompressBatch(dsts, srcs)
+	failOnError(t, "Batch compression failed", err)
+
+	compressed := make([][]byte, len(srcs))
+	outputs := make([][]byte, len(srcs))
+	for i := range srcs {
+		n, err := Compress(dsts[i], srcs[i])
+		failOnError(t, "Compression failed", err)
+		if sizes[i] != n {
+			t.Fatalf("Entry %d: batch compressed to %d bytes, Compress to %d", i, sizes[i], n)
+		}
+		compressed[i] = dsts[i][:n]
+		outputs[i] = make([]byte, len(srcs[i]))
+	}
+
+	sizes, err = UncompressBatch(outputs, compressed)
+	failOnError(t, "Batch decompression failed", err)
+	for i := range srcs {
+		if sizes[i] != len(srcs[i]) || !bytes.Equal(outputs[i], srcs[i]) {
+			t.Fatalf("Entry %d: decompressed output != input", i)
+		}
+	}
+
+	if sizes, err := CompressBatch(nil, nil); len(sizes) != 0 || err != nil {
+		t.Errorf("Empty batch should have done nothing, got %v, %v", sizes, err)
+	}
+}
+
+func TestBatchFuzz(t *testing.T) {
+	// Entries that repeat each other must still be compressed on their own.
+	f := func(inputs [][]byte, repeat uint8) bool {
+		var srcs, dsts [][]byte
+		for _, input := range inputs {
+			input = bytes.Repeat(input, int(repeat)%8+1)
+			srcs = append(srcs, input, input)
+		}
+		for _, src := range srcs {
+			dsts = append(dsts, make([]byte, CompressBound(src)))
+		}
+		sizes, err := CompressBatch(dsts, srcs)
+		failOnError(t, "Batch compression failed", err)
+		for i, src := range srcs {
+			output := make([]byte, len(src))
+			n, err := Uncompress(output, dsts[i][:sizes[i]])
+			if err != nil || !bytes.Equal(output[:n], src) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchErrors(t *testing.T) {
+	srcs := [][]byte{plaintext0, plaintext0, plaintext0}
+	dsts := [][]byte{make([]byte, 100), make([]byte, 10), nil}
+	if _, err := CompressBatch(dsts[:2], srcs); err == nil {
+		t.Errorf("Batches of different lengths should have been rejected")
+	}
+	sizes, err := CompressBatch(dsts, srcs)
+	if !errors.Is(err, ErrDstTooSmall) || sizes[0] == 0 || sizes[1] != 0 || sizes[2] != 0 {
+		t.Fatalf("Batch compression should have failed on entry 1, got %v, %v", sizes, err)
+	}
+
+	compressed := [][]byte{dsts[0][:sizes[0]], {0xf0, 1, 2}, dsts[0][:sizes[0]]}
+	outputs := [][]byte{make([]byte, len(plaintext0)), make([]byte, 100), make([]byte, 10)}
+	sizes, err = UncompressBatch(outputs, compressed)
+	if !errors.Is(err, ErrCorrupt) || sizes[0] != len(plaintext0) || sizes[1] != 0 || sizes[2] != 0 {
+		t.Fatalf("Batch decompression should have failed on entry 1, got %v, %v", sizes, err)
+	}
+	for _, empty := range [][]byte{nil, {}} {
+		sizes, err = UncompressBatch([][]byte{make([]byte, 10)}, [][]byte{empty})
+		if !errors.Is(err, ErrCorrupt) || sizes[0] != 0 {
+			t.Fatalf("Batch decompression of an empty entry should have failed with ErrCorrupt, got %v, %v", sizes, err)
+		}
+	}
+	if !bytes.Equal(outputs[0], plaintext0) {
+		t.Errorf("Entries that did not fail should have been decompressed")
+	}
+}
+
+func BenchmarkCompressBatch(b *testing.B) {
+	b.ReportAllocs()
+	srcs := make([][]byte, 1000)
+	dsts := make([][]byte, len(srcs))
+	for i := range srcs {
+		srcs[i] = plaintext0
+		dsts[i] = make([]byte, CompressBound(plaintext0))
+	}
+	b.SetBytes(int64(len(srcs) * len(plaintext0)))
+	for i := 0; i < b.N; i++ {
+		if _, err := CompressBatch(dsts, srcs); err != nil {
+			b.Errorf("CompressBatch error: %v", err)
+		}
+	}
+}
+
+// BenchmarkCompressEvents compresses the same events as
+// BenchmarkCompressBatchEvents one call at a time, for comparison.
+func BenchmarkCompressEvents(b *testing.B) {
+	srcs, dsts := benchmarkEvents()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range srcs {
+			if _, err := Compress(dsts[j], srcs[j]); err != nil {
+				b.Errorf("Compress error: %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkCompressBatchEvents(b *testing.B) {
+	srcs, dsts := benchmarkEvents()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := CompressBatch(dsts, srcs); err != nil {
+			b.Errorf("CompressBatch error: %v", err)
+		}
+	}
+}
+
+func benchmarkEvents() (srcs, dsts [][]byte) {
+	for i := 0; i < 1000; i++ {
+		srcs = append(srcs, jsonEvent(i))
+		dsts = append(dsts, make([]byte, CompressBound(srcs[i])))
+	}
+	return srcs, dsts
+}
+
+func BenchmarkUncompressBatch(b *testing.B) {
+	b.ReportAllocs()
+	compressed := make([]byte, CompressBound(plaintext0))
+	n, err := Compress(compressed, plaintext0)
+	if err != nil {
+		b.Errorf("Compress error: %v", err)
+	}
+	srcs := make([][]byte, 1000)
+	dsts := make([][]byte, len(srcs))
+	for i := range srcs {
+		srcs[i] = compressed[:n]
+		dsts[i] = make([]byte, len(plaintext0))
+	}
+	b.SetBytes(int64(len(srcs) * len(plaintext0)))
+	for i := 0; i < b.N; i++ {
+		if _, err := UncompressBatch(dsts, srcs); err != nil {
+			b.Errorf("UncompressBatch error: %v", err)
+		}
+	}
+}
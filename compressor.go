@@ -0,0 +1,62 @@
+package lz4
+
+// #include "src/lz4.h"
+// #include "src/lz4hc.h"
+import "C"
+
+import "unsafe"
+
+// Compressor compresses blocks like Compress and CompressHC, but reuses its
+// compression state between calls instead of setting it up every time.  The
+// zero value is ready to use, and a Compressor can be kept in a sync.Pool.  A
+// Compressor must not be used by more than one goroutine at a time.
+type Compressor struct {
+	// The states are kept in []uint64 so that they are aligned for pointers,
+	// as the C library requires.
+	state   []uint64
+	stateHC []uint64
+}
+
+// NewCompressor creates a new Compressor.
+func NewCompressor() *Compressor {
+	return &Compressor{}
+}
+
+// Compress is like the package level Compress.
+func (c *Compressor) Compress(out, in []byte) (int, error) {
+	return c.CompressFast(out, in, 1)
+}
+
+// CompressFast is like the package level CompressFast.
+func (c *Compressor) CompressFast(out, in []byte, acceleration int) (outSize int, err error) {
+	if c.state == nil {
+		c.state = make([]uint64, (C.LZ4_sizeofState()+7)/8)
+	}
+	outSize = int(C.LZ4_compress_fast_extState(unsafe.Pointer(&c.state[0]), p(in), p(out), clen(in), clen(out), C.int(acceleration)))
+	if outSize == 0 {
+		err = compressError(in)
+	}
+	return
+}
+
+// CompressHC is like the package level CompressHC.
+func (c *Compressor) CompressHC(out, in []byte) (int, error) {
+	return c.CompressHCLevel(out, in, 0)
+}
+
+// CompressHCLevel is like the package level CompressHCLevel.
+func (c *Compressor) CompressHCLevel(out, in []byte, level int) (outSize int, err error) {
+	// LZ4HC does not handle empty buffers. Pass through to Compress.
+	if len(in) == 0 || len(out) == 0 {
+		return c.Compress(out, in)
+	}
+
+	if c.stateHC == nil {
+		c.stateHC = make([]uint64, (C.LZ4_sizeofStateHC()+7)/8)
+	}
+	outSize = int(C.LZ4_compress_HC_extStateHC(unsafe.Pointer(&c.stateHC[0]), p(in), p(out), clen(in), clen(out), C.int(level)))
+	if outSize == 0 {
+		err = compressError(in)
+	}
+	return
+}
@@ -0,0 +1,109 @@
+package lz4
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestCompressorMatchesCompress(t *testing.T) {
+	input, err := ioutil.ReadFile("sample.txt")
+	failOnError(t, "Failed reading sample", err)
+
+	c := NewCompressor()
+	want := make([]byte, CompressBound(input))
+	got := make([]byte, CompressBound(input))
+	// Run each case twice so that the second call reuses the state.
+	for i := 0; i < 2; i++ {
+		for _, acceleration := range []int{1, 8} {
+			wantSize, err := CompressFast(want, input, acceleration)
+			failOnError(t, "Compression failed", err)
+			gotSize, err := c.CompressFast(got, input, acceleration)
+			failOnError(t, "Compressor failed", err)
+			if !bytes.Equal(want[:wantSize], got[:gotSize]) {
+				t.Fatalf("Acceleration %d: Compressor output differs from CompressFast", acceleration)
+			}
+		}
+		for _, level := range []int{0, 4, 16} {
+			wantSize, err := CompressHCLevel(want, input, level)
+			failOnError(t, "HC compression failed", err)
+			gotSize, err := c.CompressHCLevel(got, input, level)
+			failOnError(t, "HC Compressor failed", err)
+			if !bytes.Equal(want[:wantSize], got[:gotSize]) {
+				t.Fatalf("Level %d: Compressor output differs from CompressHCLevel", level)
+			}
+		}
+	}
+
+	var zero Compressor
+	outSize, err := zero.Compress(got, input)
+	failOnError(t, "Zero Compressor failed", err)
+	if outSize != corpusSize {
+		t.Fatalf("Compressed output length != expected: %d != %d", outSize, corpusSize)
+	}
+}
+
+func TestCompressorErrors(t *testing.T) {
+	input := []byte("Hello world, this is quite something")
+	var c Compressor
+	if _, err := c.Compress(make([]byte, 1), input); err == nil {
+		t.Fatalf("Compression should have failed but didn't")
+	}
+	if _, err := c.CompressHC(make([]byte, 1), input); err == nil {
+		t.Fatalf("HC compression should have failed but didn't")
+	}
+
+	outSize, err := c.CompressHC(make([]byte, 1), nil)
+	failOnError(t, "Compressing empty input failed", err)
+	if outSize != 1 {
+		t.Fatalf("Empty input should compress to 1 byte, got %d", outSize)
+	}
+}
+
+func TestCompressorPool(t *testing.T) {
+	pool := sync.Pool{New: func() interface{} { return NewCompressor() }}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			input := bytes.Repeat([]byte{byte('a' + i), ' ', 'b'}, 1000+i)
+			out := make([]byte, CompressBound(input))
+			for j := 0; j < 100; j++ {
+				c := pool.Get().(*Compressor)
+				outSize, err := c.Compress(out, input)
+				pool.Put(c)
+				if err != nil {
+					t.Errorf("Compressor failed: %v", err)
+					return
+				}
+
+				decompressed := make([]byte, len(input))
+				if _, err := Uncompress(decompressed, out[:outSize]); err != nil {
+					t.Errorf("Decompression failed: %v", err)
+					return
+				}
+				if !bytes.Equal(decompressed, input) {
+					t.Errorf("Decompressed output != input")
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkCompressor(b *testing.B) {
+	b.ReportAllocs()
+	c := NewCompressor()
+	dst := make([]byte, CompressBound(plaintext0))
+	b.SetBytes(int64(len(plaintext0)))
+	for i := 0; i < b.N; i++ {
+		_, err := c.Compress(dst, plaintext0)
+		if err != nil {
+			b.Errorf("Compress error: %v", err)
+		}
+	}
+}
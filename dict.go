@@ -0,0 +1,191 @@
+package lz4
+
+// #include <stdlib.h>
+// #include "src/lz4.h"
+// #include "src/lz4hc.h"
+//
+// void LZ4_attach_HC_dictionary(LZ4_streamHC_t* working, const LZ4_streamHC_t* dict);
+import "C"
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// maxDictSize is the size of the LZ4 window: only the last 64KB of a
+// dictionary can ever be referenced.
+const maxDictSize = 64 * 1024
+
+// Dictionary is a preloaded dictionary for CompressDict, UncompressDict and
+// their HC variants.  Data that looks like the dictionary compresses much
+// better, which helps most with small inputs.  The same dictionary must be
+// used to compress and to decompress.  A Dictionary is safe for concurrent
+// use.
+type Dictionary struct {
+	// data is kept in C memory, because lz4 streams and frame decompression
+	// contexts refer to it across calls.  It is freed when the Dictionary is
+	// garbage collected, so callers that hand it to C keep the Dictionary
+	// alive until they are done.
+	data   []byte
+	stream C.LZ4_stream_t
+
+	hcOnce   sync.Once
+	streamHC *C.LZ4_streamHC_t
+}
+
+// NewDictionary creates a new Dictionary from dict.  Only the last 64KB of
+// dict are used.  dict is copied, so the caller may reuse it.
+func NewDictionary(dict []byte) *Dictionary {
+	if len(dict) > maxDictSize {
+		dict = dict[len(dict)-maxDictSize:]
+	}
+	d := &Dictionary{}
+	if len(dict) > 0 {
+		ptr := C.malloc(C.size_t(len(dict)))
+		d.data = (*[maxDictSize]byte)(ptr)[:len(dict):len(dict)]
+		copy(d.data, dict)
+		runtime.SetFinalizer(d, (*Dictionary).free)
+	}
+	C.LZ4_loadDict(&d.stream, p(d.data), clen(d.data))
+	return d
+}
+
+// free releases the C memory of d.data.
+func (d *Dictionary) free() {
+	C.free(unsafe.Pointer(&d.data[0]))
+	d.data = nil
+}
+
+// hcStream returns the dictionary loaded into an HC stream, which is only
+// built the first time it is needed.
+func (d *Dictionary) hcStream() *C.LZ4_streamHC_t {
+	d.hcOnce.Do(func() {
+		d.streamHC = new(C.LZ4_streamHC_t)
+		C.LZ4_loadDictHC(d.streamHC, p(d.data), clen(d.data))
+	})
+	return d.streamHC
+}
+
+// CompressDict is like Compress, but uses dict to compress in.  The output
+// must be decompressed with UncompressDict and the same dictionary.
+func CompressDict(out, in []byte, dict *Dictionary) (outSize int, err error) {
+	return compressDictFast(out, in, dict, 1)
+}
+
+// compressDictFast is like CompressDict, with the acceleration of
+// CompressFast.
+func compressDictFast(out, in []byte, dict *Dictionary, acceleration int) (outSize int, err error) {
+	// Compressing with a stream updates it, so work on a copy.
+	stream := dict.stream
+	outSize = int(C.LZ4_compress_fast_continue(&stream, p(in), p(out), clen(in), clen(out), C.int(acceleration)))
+	runtime.KeepAlive(dict)
+	if outSize == 0 {
+		err = compressError(in)
+	}
+	return
+}
+
+// hcStreamPool holds the HC streams CompressDictHCLevel works on, since they
+// are too large to allocate for every call.
+var hcStreamPool = sync.Pool{New: func() interface{} { return new(C.LZ4_streamHC_t) }}
+
+// CompressDictHC is like CompressHC, but uses dict to compress in.
+func CompressDictHC(out, in []byte, dict *Dictionary) (int, error) {
+	return CompressDictHCLevel(out, in, dict, 0)
+}
+
+// CompressDictHCLevel is like CompressHCLevel, but uses dict to compress in.
+func CompressDictHCLevel(out, in []byte, dict *Dictionary, level int) (outSize int, err error) {
+	// LZ4HC does not handle empty buffers. Pass through to CompressDict.
+	if len(in) == 0 || len(out) == 0 {
+		return CompressDict(out, in, dict)
+	}
+
+	// Compressing with a stream updates it, so work on a copy.
+	stream := hcStreamPool.Get().(*C.LZ4_streamHC_t)
+	defer hcStreamPool.Put(stream)
+	C.LZ4_resetStreamHC(stream, C.int(level))
+	C.LZ4_attach_HC_dictionary(stream, dict.hcStream())
+	outSize = int(C.LZ4_compress_HC_continue(stream, p(in), p(out), clen(in), clen(out)))
+	runtime.KeepAlive(dict)
+	if outSize == 0 {
+		err = compressError(in)
+	}
+	return
+}
+
+// UncompressDict is like Uncompress, for data compressed with dict.
+func UncompressDict(out, in []byte, dict *Dictionary) (outSize int, err error) {
+	if len(in) == 0 {
+		return 0, ErrCorrupt
+	}
+	outSize = int(C.LZ4_decompress_safe_usingDict(p(in), p(out), clen(in), clen(out), p(dict.data), clen(dict.data)))
+	runtime.KeepAlive(dict)
+	if outSize < 0 {
+		err = uncompressError(in, len(dict.data), len(out))
+	}
+	return
+}
+
+// NewWriterDict is like NewWriter, but primes the stream with dict, so that
+// even the first block can refer to it.  The output must be read with
+// NewReaderDict and the same dictionary.
+func NewWriterDict(w io.Writer, dict *Dictionary) *Writer {
+	writer := NewWriter(w)
+	stream := writer.stream.(*fastStream)
+	stream.dict = dict
+	stream.reset()
+	return writer
+}
+
+// NewReaderDict is like NewReader, for streams written by NewWriterDict with
+// dict.
+func NewReaderDict(r io.Reader, dict *Dictionary) io.ReadCloser {
+	rdr := &reader{dict: dict}
+	rdr.Reset(r)
+	return rdr
+}
+
+// DictionaryRegistry maps the dictionary IDs found in LZ4 frame headers to
+// dictionaries, so that a FrameReader can find the dictionary each frame was
+// compressed with.  Several generations of a dictionary can be registered
+// side by side.  A DictionaryRegistry is safe for concurrent use.
+type DictionaryRegistry struct {
+	mu    sync.RWMutex
+	dicts map[uint32]*Dictionary
+}
+
+// NewDictionaryRegistry creates a new, empty DictionaryRegistry.
+func NewDictionaryRegistry() *DictionaryRegistry {
+	return &DictionaryRegistry{dicts: make(map[uint32]*Dictionary)}
+}
+
+// Register adds dict to the registry under id, replacing any dictionary
+// registered under the same id.  id must not be 0, which in a frame header
+// means that there is no dictionary.
+func (r *DictionaryRegistry) Register(id uint32, dict *Dictionary) error {
+	if id == 0 {
+		return errors.New("lz4: dictionary ID 0 is reserved")
+	}
+	if dict == nil {
+		return errors.New("lz4: nil dictionary")
+	}
+	r.mu.Lock()
+	r.dicts[id] = dict
+	r.mu.Unlock()
+	return nil
+}
+
+// Lookup returns the dictionary registered under id, or nil if there is none.
+// It may be called on a nil DictionaryRegistry.
+func (r *DictionaryRegistry) Lookup(id uint32) *Dictionary {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.dicts[id]
+}
@@ -0,0 +1,188 @@
+package lz4
+
+// dict_builder.go builds dictionaries from sample data.  It is a simplified
+// version of the cover algorithm used by zstd: the samples are split into
+// epochs, and from each epoch the segment covering the most frequent dmers
+// (short substrings) not already in the dictionary is picked, until the
+// dictionary is full.
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	// dictDmerSize is the length of the substrings whose frequency is
+	// counted.
+	dictDmerSize = 8
+	// dictSegmentSize is the most that is added to the dictionary at once.
+	dictSegmentSize = 128
+)
+
+// dictSegment is a piece of a sample picked for the dictionary.
+type dictSegment struct {
+	data  []byte
+	score int
+}
+
+// BuildDictionary builds a dictionary of at most maxSize bytes from samples,
+// for use with NewDictionary.  It picks the substrings that recur in the most
+// samples, and puts the most valuable ones at the end of the dictionary,
+// where they are cheapest to refer to and survive longest in the window.
+// maxSize is capped at 64KB, the most LZ4 can use.  The samples should be
+// representative of the data to compress, and there should be many of them.
+func BuildDictionary(samples [][]byte, maxSize int) []byte {
+	if maxSize > maxDictSize {
+		maxSize = maxDictSize
+	}
+	if maxSize <= 0 {
+		return nil
+	}
+
+	// freqs counts the samples each dmer appears in, rather than how often
+	// it appears: LZ4 finds repetitions within a sample on its own.
+	freqs := make(map[uint64]int)
+	total := 0
+	for _, sample := range samples {
+		seen := make(map[uint64]bool)
+		for i := 0; i+dictDmerSize <= len(sample); i++ {
+			d := dmer(sample, i)
+			if !seen[d] {
+				seen[d] = true
+				freqs[d]++
+			}
+		}
+		total += len(sample)
+	}
+
+	epochs := splitEpochs(samples, total, maxSize/dictSegmentSize)
+	var segments []dictSegment
+	size := 0
+	for size < maxSize {
+		progress := false
+		for _, epoch := range epochs {
+			if size >= maxSize {
+				break
+			}
+			segment := bestSegment(epoch, freqs)
+			if segment.score == 0 {
+				continue
+			}
+			progress = true
+
+			// The segment's dmers are in the dictionary now, so they are
+			// worth nothing to the next segments.
+			for i := 0; i+dictDmerSize <= len(segment.data); i++ {
+				delete(freqs, dmer(segment.data, i))
+			}
+			if len(segment.data) > maxSize-size {
+				segment.data = segment.data[:maxSize-size]
+			}
+			segments = append(segments, segment)
+			size += len(segment.data)
+		}
+		if !progress {
+			break
+		}
+	}
+
+	// Put the best segments last.
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].score < segments[j].score
+	})
+	dict := make([]byte, 0, size)
+	for _, segment := range segments {
+		dict = append(dict, segment.data...)
+	}
+	return dict
+}
+
+// dmer returns the dmer at b[i:] packed into an integer.
+func dmer(b []byte, i int) uint64 {
+	return binary.LittleEndian.Uint64(b[i:])
+}
+
+// splitEpochs splits samples, which hold total bytes, into n epochs of about
+// the same size.  Samples larger than an epoch are cut up.
+func splitEpochs(samples [][]byte, total, n int) [][][]byte {
+	if n < 1 {
+		n = 1
+	}
+	epochSize := total/n + 1
+
+	epochs := make([][][]byte, 0, n)
+	var epoch [][]byte
+	epochLen := 0
+	for _, sample := range samples {
+		for len(sample) > 0 {
+			piece := sample
+			if len(piece) > epochSize-epochLen {
+				piece = piece[:epochSize-epochLen]
+			}
+			epoch = append(epoch, piece)
+			epochLen += len(piece)
+			sample = sample[len(piece):]
+
+			if epochLen == epochSize {
+				epochs = append(epochs, epoch)
+				epoch, epochLen = nil, 0
+			}
+		}
+	}
+	if len(epoch) > 0 {
+		epochs = append(epochs, epoch)
+	}
+	return epochs
+}
+
+// bestSegment finds the segment of at most dictSegmentSize bytes in epoch
+// whose distinct dmers have the highest total frequency.  The segment is
+// trimmed of dmers that are worth nothing.
+func bestSegment(epoch [][]byte, freqs map[uint64]int) dictSegment {
+	const window = dictSegmentSize - dictDmerSize + 1
+
+	var best dictSegment
+	var bestPiece []byte
+	bestStart, bestEnd := 0, 0
+	for _, piece := range epoch {
+		active := make(map[uint64]int)
+		score := 0
+		for i := 0; i+dictDmerSize <= len(piece); i++ {
+			d := dmer(piece, i)
+			if active[d] == 0 {
+				score += freqs[d]
+			}
+			active[d]++
+
+			if i >= window {
+				old := dmer(piece, i-window)
+				active[old]--
+				if active[old] == 0 {
+					score -= freqs[old]
+					delete(active, old)
+				}
+			}
+
+			if score > best.score {
+				best.score = score
+				bestPiece = piece
+				bestStart, bestEnd = i-window+1, i
+				if bestStart < 0 {
+					bestStart = 0
+				}
+			}
+		}
+	}
+	if best.score == 0 {
+		return best
+	}
+
+	for freqs[dmer(bestPiece, bestStart)] == 0 {
+		bestStart++
+	}
+	for freqs[dmer(bestPiece, bestEnd)] == 0 {
+		bestEnd--
+	}
+	best.data = bestPiece[bestStart : bestEnd+dictDmerSize]
+	return best
+}
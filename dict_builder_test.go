@@ -0,0 +1,77 @@
+package lz4
+
+import (
+	"bytes"
+	"testing"
+)
+
+func jsonSamples(n int) [][]byte {
+	samples := make([][]byte, n)
+	for i := range samples {
+		samples[i] = jsonEvent(i)
+	}
+	return samples
+}
+
+func TestBuildDictionary(t *testing.T) {
+	samples := jsonSamples(1000)
+	built := BuildDictionary(samples, 1024)
+	if len(built) == 0 || len(built) > 1024 {
+		t.Fatalf("Dictionary size should be in (0, 1024], was %d", len(built))
+	}
+	if !bytes.Equal(built, BuildDictionary(samples, 1024)) {
+		t.Fatalf("Building the same dictionary twice gave different results")
+	}
+	// The most common substrings should be at the end.
+	if !bytes.Contains(built[len(built)-dictSegmentSize:], []byte(`"service":"checkout","status":"ok"`)) {
+		t.Errorf("Dictionary should end with the common parts of the samples: %q", built)
+	}
+
+	dict := NewDictionary(built)
+	plainTotal, dictTotal := 0, 0
+	for i := 5000; i < 5100; i++ {
+		input := jsonEvent(i)
+		output := make([]byte, CompressBound(input))
+		n, err := Compress(output, input)
+		failOnError(t, "Compression failed", err)
+		plainTotal += n
+
+		n, err = CompressDict(output, input, dict)
+		failOnError(t, "Compression with dictionary failed", err)
+		dictTotal += n
+
+		decompressed := make([]byte, len(input))
+		_, err = UncompressDict(decompressed, output[:n], dict)
+		failOnError(t, "Decompression failed", err)
+		if !bytes.Equal(decompressed, input) {
+			t.Fatalf("Decompressed output != input")
+		}
+	}
+	if dictTotal >= plainTotal/2 {
+		t.Errorf("Built dictionary should have helped: %d bytes vs %d without", dictTotal, plainTotal)
+	}
+}
+
+func TestBuildDictionarySizes(t *testing.T) {
+	samples := jsonSamples(2000)
+	for _, maxSize := range []int{1, 100, 4096, maxDictSize, 1 << 20} {
+		built := BuildDictionary(samples, maxSize)
+		limit := maxSize
+		if limit > maxDictSize {
+			limit = maxDictSize
+		}
+		if len(built) > limit {
+			t.Errorf("maxSize %d: dictionary is too large: %d bytes", maxSize, len(built))
+		}
+	}
+
+	if built := BuildDictionary(samples, 0); len(built) != 0 {
+		t.Errorf("maxSize 0 should give an empty dictionary, got %d bytes", len(built))
+	}
+	if built := BuildDictionary(nil, 1024); len(built) != 0 {
+		t.Errorf("No samples should give an empty dictionary, got %d bytes", len(built))
+	}
+	if built := BuildDictionary([][]byte{[]byte("tiny"), nil}, 1024); len(built) != 0 {
+		t.Errorf("Tiny samples should give an empty dictionary, got %d bytes", len(built))
+	}
+}
@@ -0,0 +1,280 @@
+package lz4
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"testing/quick"
+)
+
+func jsonEvent(i int) []byte {
+	return []byte(fmt.Sprintf(`{"timestamp":%d,"host":"web-%02d.example.com","service":"checkout","status":"ok","duration_ms":%d,"tags":["env:prod","region:us-east-1"]}`,
+		1500000000+i*7, i%13, i*31%997))
+}
+
+func jsonDictionary() *Dictionary {
+	var samples []byte
+	for i := 0; i < 20; i++ {
+		samples = append(samples, jsonEvent(1000+i)...)
+	}
+	return NewDictionary(samples)
+}
+
+func TestCompressDict(t *testing.T) {
+	dict := jsonDictionary()
+	input := jsonEvent(1)
+
+	output := make([]byte, CompressBound(input))
+	plainSize, err := Compress(output, input)
+	failOnError(t, "Compression failed", err)
+
+	for name, compress := range map[string]func(out, in []byte, dict *Dictionary) (int, error){
+		"CompressDict":   CompressDict,
+		"CompressDictHC": CompressDictHC,
+		"CompressDictHCLevel16": func(out, in []byte, dict *Dictionary) (int, error) {
+			return CompressDictHCLevel(out, in, dict, 16)
+		},
+	} {
+		outSize, err := compress(output, input, dict)
+		failOnError(t, name+" failed", err)
+		if outSize >= plainSize/2 {
+			t.Errorf("%s: dictionary should have helped: %d bytes vs %d without", name, outSize, plainSize)
+		}
+
+		decompressed := make([]byte, len(input))
+		n, err := UncompressDict(decompressed, output[:outSize], dict)
+		failOnError(t, name+": decompression failed", err)
+		if !bytes.Equal(decompressed[:n], input) {
+			t.Fatalf("%s: decompressed output != input: %q", name, decompressed[:n])
+		}
+	}
+}
+
+func TestCompressDictLarge(t *testing.T) {
+	sample, err := ioutil.ReadFile("sample.txt")
+	failOnError(t, "Failed reading sample", err)
+
+	// Only the last 64KB of a dictionary are used.
+	big := bytes.Repeat([]byte("unused "), maxDictSize)
+	dict := NewDictionary(append(big, sample...))
+	if len(dict.data) != maxDictSize || !bytes.HasSuffix(dict.data, sample) {
+		t.Fatalf("Dictionary should hold the last %d bytes, holds %d", maxDictSize, len(dict.data))
+	}
+
+	input := bytes.Repeat(sample, 4)
+	output := make([]byte, CompressBound(input))
+	outSize, err := CompressDict(output, input, dict)
+	failOnError(t, "Compression failed", err)
+	decompressed := make([]byte, len(input))
+	_, err = UncompressDict(decompressed, output[:outSize], dict)
+	failOnError(t, "Decompression failed", err)
+	if !bytes.Equal(decompressed, input) {
+		t.Fatalf("Decompressed output != input")
+	}
+}
+
+func TestCompressDictHCAllocs(t *testing.T) {
+	dict := jsonDictionary()
+	input := jsonEvent(1)
+	output := make([]byte, CompressBound(input))
+	allocs := testing.AllocsPerRun(100, func() {
+		_, err := CompressDictHC(output, input, dict)
+		failOnError(t, "Compression failed", err)
+	})
+	if allocs >= 1 {
+		t.Errorf("CompressDictHC should reuse its stream, allocated %v times per call", allocs)
+	}
+}
+
+func TestCompressDictErrors(t *testing.T) {
+	dict := jsonDictionary()
+	input := jsonEvent(2)
+
+	if _, err := CompressDict(make([]byte, 1), input, dict); err == nil {
+		t.Fatalf("Compression should have failed but didn't")
+	}
+	if _, err := CompressDictHC(make([]byte, 1), input, dict); err == nil {
+		t.Fatalf("HC compression should have failed but didn't")
+	}
+
+	output := make([]byte, CompressBound(input))
+	outSize, err := CompressDict(output, input, dict)
+	failOnError(t, "Compression failed", err)
+	if _, err := UncompressDict(make([]byte, len(input)-1), output[:outSize], dict); err == nil {
+		t.Fatalf("Decompression into a short buffer should have failed but didn't")
+	}
+
+	// Without the dictionary the output cannot be decompressed.
+	decompressed := make([]byte, len(input))
+	n, err := Uncompress(decompressed, output[:outSize])
+	if err == nil && bytes.Equal(decompressed[:n], input) {
+		t.Fatalf("Decompression without the dictionary should have failed but didn't")
+	}
+}
+
+func TestCompressDictConcurrently(t *testing.T) {
+	dict := jsonDictionary()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				input := jsonEvent(i*100 + j)
+				output := make([]byte, CompressBound(input))
+				compress := CompressDict
+				if j%10 == 0 {
+					compress = CompressDictHC
+				}
+				outSize, err := compress(output, input, dict)
+				if err != nil {
+					t.Errorf("Compression failed: %v", err)
+					return
+				}
+				decompressed := make([]byte, len(input))
+				if _, err := UncompressDict(decompressed, output[:outSize], dict); err != nil || !bytes.Equal(decompressed, input) {
+					t.Errorf("Decompressed output != input (err: %v)", err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestCompressDictFuzz(t *testing.T) {
+	f := func(dictData, input []byte) bool {
+		dict := NewDictionary(dictData)
+		output := make([]byte, CompressBound(input))
+		outSize, err := CompressDict(output, input, dict)
+		if err != nil {
+			t.Fatalf("Compression failed: %v", err)
+		}
+		decompressed := make([]byte, len(input))
+		n, err := UncompressDict(decompressed, output[:outSize], dict)
+		if err != nil {
+			t.Fatalf("Decompression failed: %v", err)
+		}
+		return bytes.Equal(decompressed[:n], input)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamDict(t *testing.T) {
+	dict := jsonDictionary()
+	input := append(jsonEvent(3), jsonEvent(4)...)
+
+	var plain bytes.Buffer
+	w := NewWriter(&plain)
+	_, err := w.Write(input)
+	failOnError(t, "Failed writing to compress object", err)
+	failOnError(t, "Failed closing writer", w.Close())
+
+	var compressed bytes.Buffer
+	w = NewWriterDict(&compressed, dict)
+	_, err = w.Write(input)
+	failOnError(t, "Failed writing to compress object", err)
+	failOnError(t, "Failed closing writer", w.Close())
+	if compressed.Len() >= plain.Len()/2 {
+		t.Errorf("Dictionary should have helped: %d bytes vs %d without", compressed.Len(), plain.Len())
+	}
+
+	r := NewReaderDict(bytes.NewReader(compressed.Bytes()), dict)
+	defer r.Close()
+	output, err := ioutil.ReadAll(r)
+	failOnError(t, "Failed to decompress", err)
+	if !bytes.Equal(input, output) {
+		t.Fatalf("Decompressed output != input: %q", output)
+	}
+
+	// Without the dictionary the stream cannot be decompressed.
+	r = NewReader(bytes.NewReader(compressed.Bytes()))
+	defer r.Close()
+	output, err = ioutil.ReadAll(r)
+	if err == nil && bytes.Equal(input, output) {
+		t.Fatalf("Decompression without the dictionary should have failed but didn't")
+	}
+}
+
+func TestStreamDictGC(t *testing.T) {
+	// The dictionaries are only referenced by the writer and the reader,
+	// which must keep them alive.
+	var compressed bytes.Buffer
+	w := NewWriterDict(&compressed, jsonDictionary())
+	defer w.Close()
+	r := NewReaderDict(&compressed, jsonDictionary())
+	defer r.Close()
+	for i := 0; i < 5; i++ {
+		runtime.GC()
+		input := jsonEvent(i)
+		_, err := w.Write(input)
+		failOnError(t, "Failed writing to compress object", err)
+		failOnError(t, "Failed flushing writer", w.Flush())
+		output := make([]byte, len(input))
+		_, err = io.ReadFull(r, output)
+		failOnError(t, "Failed to decompress", err)
+		if !bytes.Equal(input, output) {
+			t.Fatalf("Decompressed output != input: %q", output)
+		}
+	}
+}
+
+func TestStreamDictMultipleBlocks(t *testing.T) {
+	sample, err := ioutil.ReadFile("sample.txt")
+	failOnError(t, "Failed reading sample", err)
+	dict := NewDictionary(sample)
+	input := []byte(strings.Repeat(string(sample), 40))
+
+	var compressed bytes.Buffer
+	w := NewWriterDict(&compressed, dict)
+	for rest := input; len(rest) > 0; {
+		n := 10007
+		if n > len(rest) {
+			n = len(rest)
+		}
+		_, err := w.Write(rest[:n])
+		failOnError(t, "Failed writing to compress object", err)
+		rest = rest[n:]
+	}
+	failOnError(t, "Failed closing writer", w.Close())
+
+	r := NewReaderDict(&compressed, dict)
+	defer r.Close()
+	output, err := ioutil.ReadAll(r)
+	failOnError(t, "Failed to decompress", err)
+	if !bytes.Equal(input, output) {
+		t.Fatalf("Decompressed output != input (lengths: %v bytes & %v bytes)", len(output), len(input))
+	}
+}
+
+func TestDictionaryRegistry(t *testing.T) {
+	var nilRegistry *DictionaryRegistry
+	if nilRegistry.Lookup(1) != nil {
+		t.Fatal("Lookup in a nil registry should find nothing")
+	}
+
+	dicts := NewDictionaryRegistry()
+	if err := dicts.Register(0, jsonDictionary()); err == nil {
+		t.Fatal("Registering dictionary ID 0 should have failed")
+	}
+	if err := dicts.Register(1, nil); err == nil {
+		t.Fatal("Registering a nil dictionary should have failed")
+	}
+
+	first, second := jsonDictionary(), jsonDictionary()
+	failOnError(t, "Failed registering dictionary", dicts.Register(1, first))
+	if dicts.Lookup(1) != first || dicts.Lookup(2) != nil {
+		t.Fatal("Lookup did not find the registered dictionary")
+	}
+	failOnError(t, "Failed registering dictionary", dicts.Register(1, second))
+	if dicts.Lookup(1) != second {
+		t.Fatal("Register did not replace the dictionary")
+	}
+}
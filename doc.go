@@ -1,5 +1,13 @@
 // Package lz4 implements compression using lz4.c and lz4hc.c
 //
+// Besides the LZ4 frame format of FrameWriter and FrameReader, the package
+// has its own streaming format, written by Writer and read by NewReader: a
+// sequence of blocks, each prefixed with its compressed size as 4 bytes
+// little endian, and ended by a block size of 0.  The end marker lets the
+// reader tell a complete stream from a truncated one.  Older versions of the
+// package did not write it, so NewReader fails with ErrTruncated at the end
+// of streams they wrote, after returning all of their data.
+//
 // Copyright (c) 2016 Datadog
 // Copyright (c) 2013 CloudFlare, Inc.
 package lz4
@@ -0,0 +1,72 @@
+package lz4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors returned by this package.  They are often wrapped with more details,
+// so test for them with errors.Is.
+var (
+	// ErrCorrupt means that compressed data is malformed.
+	ErrCorrupt = errors.New("lz4: malformed compression stream")
+	// ErrDstTooSmall means that the destination buffer is too small to hold
+	// the result.
+	ErrDstTooSmall = errors.New("lz4: insufficient space in destination buffer")
+	// ErrInputTooLarge means that the input is larger than MaxInputSize.
+	ErrInputTooLarge = errors.New("lz4: input is too large")
+	// ErrChecksum means that data does not match its checksum.
+	ErrChecksum = errors.New("lz4: checksum mismatch")
+	// ErrTruncated means that compressed data ends early.  errors.Is also
+	// reports it as io.ErrUnexpectedEOF.
+	ErrTruncated = fmt.Errorf("lz4: truncated input: %w", io.ErrUnexpectedEOF)
+	// ErrUnknownDictionary is returned by the frame readers when a frame
+	// declares a dictionary ID that is not registered.
+	ErrUnknownDictionary = errors.New("lz4: unknown dictionary ID")
+	// ErrClosed is returned by readers and writers that are used after
+	// Close.
+	ErrClosed = errors.New("lz4: use of closed reader or writer")
+	// ErrOutputTooLarge is returned by UncompressAlloc when the decompressed
+	// data would be larger than the maximum size allowed.
+	ErrOutputTooLarge = errors.New("lz4: decompressed output is too large")
+)
+
+// StreamError is returned by the readers of block based streams when they
+// fail, and tells where in the stream it happened.
+type StreamError struct {
+	// Block is the index of the failing block, counting from 0.
+	Block int
+	// Offset is the offset of the start of that block in the compressed
+	// stream.
+	Offset int64
+	// Err is the cause of the failure.
+	Err error
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("%v (block %d at offset %d)", e.Err, e.Block, e.Offset)
+}
+
+// Unwrap returns e.Err, for errors.Is and errors.As.
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// compressError returns the error for a failed compression of in.
+func compressError(in []byte) error {
+	if len(in) > MaxInputSize {
+		return ErrInputTooLarge
+	}
+	return ErrDstTooSmall
+}
+
+// uncompressError returns the error for a failed decompression of in, which
+// lz4 reports the same way whether in is corrupt or outSize too small.
+// dictSize is the size of the dictionary in was compressed with, if any.
+func uncompressError(in []byte, dictSize, outSize int) error {
+	if blockSizeExceeds(in, dictSize, outSize) {
+		return ErrDstTooSmall
+	}
+	return ErrCorrupt
+}
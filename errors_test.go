@@ -0,0 +1,126 @@
+package lz4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBlockErrors(t *testing.T) {
+	input, err := ioutil.ReadFile("sample.txt")
+	failOnError(t, "Failed reading sample", err)
+	compressed, err := AppendCompress(nil, input)
+	failOnError(t, "Compression failed", err)
+
+	if _, err := Uncompress(make([]byte, len(input)-1), compressed); !errors.Is(err, ErrDstTooSmall) {
+		t.Errorf("Decompression into a short buffer should have failed with ErrDstTooSmall, got %v", err)
+	}
+	for _, corrupt := range [][]byte{compressed[:len(compressed)/2], {0xf0, 1, 2, 3}, {0x10, 'a', 5, 0}} {
+		if _, err := Uncompress(make([]byte, len(input)), corrupt); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Decompression of corrupt data should have failed with ErrCorrupt, got %v", err)
+		}
+	}
+
+	compressFuncs := map[string]func(out, in []byte) (int, error){
+		"Compress":            Compress,
+		"CompressHC":          CompressHC,
+		"Compressor.Compress": NewCompressor().Compress,
+		"CompressDict": func(out, in []byte) (int, error) {
+			return CompressDict(out, in, jsonDictionary())
+		},
+	}
+	for name, compress := range compressFuncs {
+		if _, err := compress(make([]byte, 10), input); !errors.Is(err, ErrDstTooSmall) {
+			t.Errorf("%s into a short buffer should have failed with ErrDstTooSmall, got %v", name, err)
+		}
+	}
+
+	dict := jsonDictionary()
+	event := jsonEvent(1)
+	compressed, err = AppendCompress(nil, event)
+	failOnError(t, "Compression failed", err)
+	n, err := CompressDict(compressed[:cap(compressed)], event, dict)
+	failOnError(t, "Compression failed", err)
+	if _, err := UncompressDict(make([]byte, len(event)-1), compressed[:n], dict); !errors.Is(err, ErrDstTooSmall) {
+		t.Errorf("Decompression into a short buffer should have failed with ErrDstTooSmall, got %v", err)
+	}
+
+	if err := UncompressHdr(nil, []byte{1, 2}); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Decompression of a short header should have failed with ErrTruncated, got %v", err)
+	}
+	if !errors.Is(ErrTruncated, io.ErrUnexpectedEOF) {
+		t.Errorf("ErrTruncated should also be io.ErrUnexpectedEOF")
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	input := make([]byte, 3*streamingBlockSize)
+	for i := range input {
+		input[i] = byte(i * i / 7)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	_, err := w.Write(input)
+	failOnError(t, "Failed writing to compress object", err)
+	failOnError(t, "Failed closing writer", w.Close())
+	stream := buf.Bytes()
+
+	// Find the start of the third block, and corrupt it.
+	offset := 0
+	for i := 0; i < 2; i++ {
+		offset += 4 + int(binary.LittleEndian.Uint32(stream[offset:]))
+	}
+	corrupt := append([]byte(nil), stream...)
+	binary.LittleEndian.PutUint32(corrupt[offset+4+1:], 0xFFFFFFFF)
+
+	r := NewReader(bytes.NewReader(corrupt))
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) || !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Reading a corrupt stream should have failed with a StreamError wrapping ErrCorrupt, got %v", err)
+	}
+	if streamErr.Block != 2 || streamErr.Offset != int64(offset) {
+		t.Errorf("StreamError should point to block 2 at offset %d, got block %d at offset %d", offset, streamErr.Block, streamErr.Offset)
+	}
+
+	r = NewReader(bytes.NewReader(stream[:offset+10]))
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	if !errors.As(err, &streamErr) || !errors.Is(err, ErrTruncated) || streamErr.Block != 2 {
+		t.Fatalf("Reading a truncated stream should have failed with a StreamError wrapping ErrTruncated, got %v", err)
+	}
+
+	compressed := compressLegacy(t, []byte("Hello world, this is quite something"))
+	_, err = ioutil.ReadAll(NewLegacyReader(bytes.NewReader(compressed[:len(compressed)-1])))
+	if !errors.As(err, &streamErr) || !errors.Is(err, ErrTruncated) || streamErr.Block != 0 || streamErr.Offset != 4 {
+		t.Fatalf("Reading a truncated legacy stream should have failed with a StreamError wrapping ErrTruncated, got %v", err)
+	}
+}
+
+func TestFrameErrors(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewFrameWriterOptions(&buf, FrameOptions{ContentChecksum: true})
+	failOnError(t, "Failed creating frame writer", err)
+	_, err = w.Write([]byte("Hello world, this is quite something"))
+	failOnError(t, "Failed writing to frame writer", err)
+	failOnError(t, "Failed closing frame writer", w.Close())
+	compressed := buf.Bytes()
+
+	// Flip a bit of the content checksum.
+	compressed[len(compressed)-1] ^= 1
+	r := NewFrameReader(bytes.NewReader(compressed))
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("Reading a frame with a bad checksum should have failed with ErrChecksum, got %v", err)
+	}
+
+	r = NewFrameReader(bytes.NewReader([]byte("not an lz4 frame")))
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Reading garbage should have failed with ErrCorrupt, got %v", err)
+	}
+}
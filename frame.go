@@ -0,0 +1,656 @@
+package lz4
+
+// frame.go wraps lz4frame.c to read and write the standard LZ4 frame format,
+// which is what the lz4 command line tool and most other lz4 libraries use.
+
+// #cgo CFLAGS: -O3
+// #include <string.h>
+// #include "src/lz4.h"
+// #include "src/lz4hc.h"
+//
+// /* The vendored lz4frame.c is newer than lz4.c and lz4hc.c.  Map the few
+//  * helpers it expects onto their older equivalents. */
+// #define LZ4HC_CLEVEL_MIN     3
+// #define LZ4HC_CLEVEL_DEFAULT 9
+// #define LZ4HC_CLEVEL_MAX     16
+// #define LZ4_resetStream_fast(s) LZ4_resetStream(s)
+// #define LZ4_resetStreamHC_fast(s, level) LZ4_resetStreamHC(s, level)
+// #define LZ4_setCompressionLevel(s, level) LZ4_resetStreamHC(s, level)
+// #define LZ4_favorDecompressionSpeed(s, favor) ((void)(s), (void)(favor))
+// #define LZ4_compress_fast_extState_fastReset LZ4_compress_fast_extState
+// #define LZ4_compress_HC_extStateHC_fastReset LZ4_compress_HC_extStateHC
+//
+// static void LZ4_attach_dictionary(LZ4_stream_t* working, const LZ4_stream_t* dict) {
+//     if (dict != NULL) memcpy(working, dict, sizeof(LZ4_stream_t));
+// }
+// void LZ4_attach_HC_dictionary(LZ4_streamHC_t* working, const LZ4_streamHC_t* dict);
+//
+// #include "src/xxhash.c"
+// #include "src/lz4frame.h"
+// #include "src/lz4frame.c"
+import "C"
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"unsafe"
+)
+
+// frameChunkSize is how much input is handed to lz4frame at once.  It bounds
+// the size of the compression buffer regardless of the size of each Write.
+const frameChunkSize = 64 * 1024
+
+// BlockSize selects the maximum uncompressed size of each block in a frame.
+// Its values are the block maximum size IDs of the LZ4 frame format.
+type BlockSize int
+
+// Block sizes supported by the LZ4 frame format.
+const (
+	BlockSizeDefault BlockSize = 0 // same as BlockSize64KB
+	BlockSize64KB    BlockSize = 4
+	BlockSize256KB   BlockSize = 5
+	BlockSize1MB     BlockSize = 6
+	BlockSize4MB     BlockSize = 7
+)
+
+// bytes returns the size in bytes of b, or 0 if b is not a valid block size.
+func (b BlockSize) bytes() int {
+	switch b {
+	case BlockSizeDefault, BlockSize64KB:
+		return 64 * 1024
+	case BlockSize256KB:
+		return 256 * 1024
+	case BlockSize1MB:
+		return 1024 * 1024
+	case BlockSize4MB:
+		return 4 * 1024 * 1024
+	}
+	return 0
+}
+
+// BlockMode selects whether blocks of a frame may reference data from the
+// blocks that precede them.
+type BlockMode int
+
+const (
+	// BlockLinked blocks compress better, especially with small block sizes.
+	BlockLinked BlockMode = iota
+	// BlockIndependent blocks can be decompressed on their own.
+	BlockIndependent
+)
+
+// FrameOptions controls how a FrameWriter compresses its input.  The zero
+// value uses the defaults of the lz4 library: 64KB linked blocks without
+// checksums, compressed at the fastest level.
+type FrameOptions struct {
+	// BlockSize is the maximum uncompressed size of each block.
+	BlockSize BlockSize
+	// BlockMode selects linked or independent blocks.
+	BlockMode BlockMode
+	// ContentChecksum appends a checksum of the uncompressed content to the
+	// end of the frame.
+	ContentChecksum bool
+	// BlockChecksum appends a checksum of the compressed data to each block.
+	BlockChecksum bool
+	// ContentSize declares the uncompressed size of the frame in its header.
+	// 0 means unknown.  If set, Close fails unless exactly that many bytes
+	// were written.
+	ContentSize uint64
+	// CompressionLevel is 0 for fast compression, or in the inclusive range 3
+	// (fastest) through 16 (best) for high compression.  Negative values trade
+	// ratio for even faster compression.
+	CompressionLevel int
+	// AutoFlush writes out every Write as soon as it is compressed instead of
+	// waiting for a full block.
+	AutoFlush bool
+	// Dictionary, if set, is used to compress the frame, and DictID, which
+	// must then be set too, is written to the frame header to identify it.
+	// Readers find the dictionary by its ID in the DictionaryRegistry set
+	// with FrameReader.SetDictionaries.
+	Dictionary *Dictionary
+	DictID     uint32
+}
+
+// preferences converts o into the lz4frame representation.
+func (o *FrameOptions) preferences() (prefs C.LZ4F_preferences_t, err error) {
+	if o.BlockSize.bytes() == 0 {
+		return prefs, fmt.Errorf("lz4: invalid block size %d", o.BlockSize)
+	}
+	if o.BlockMode != BlockLinked && o.BlockMode != BlockIndependent {
+		return prefs, fmt.Errorf("lz4: invalid block mode %d", o.BlockMode)
+	}
+	if (o.Dictionary == nil) != (o.DictID == 0) {
+		return prefs, errors.New("lz4: Dictionary and DictID must be set together")
+	}
+	prefs.frameInfo.blockSizeID = C.LZ4F_blockSizeID_t(o.BlockSize)
+	prefs.frameInfo.blockMode = C.LZ4F_blockMode_t(o.BlockMode)
+	if o.ContentChecksum {
+		prefs.frameInfo.contentChecksumFlag = C.LZ4F_contentChecksumEnabled
+	}
+	if o.BlockChecksum {
+		prefs.frameInfo.blockChecksumFlag = C.LZ4F_blockChecksumEnabled
+	}
+	prefs.frameInfo.contentSize = C.ulonglong(o.ContentSize)
+	prefs.frameInfo.dictID = C.uint(o.DictID)
+	prefs.compressionLevel = C.int(o.CompressionLevel)
+	if o.AutoFlush {
+		prefs.autoFlush = 1
+	}
+	return prefs, nil
+}
+
+// FrameInfo describes an LZ4 frame as declared in its header.
+type FrameInfo struct {
+	// BlockSize is the maximum uncompressed size of each block.
+	BlockSize BlockSize
+	// BlockMode tells whether blocks are linked or independent.
+	BlockMode BlockMode
+	// ContentChecksum is set if the frame ends with a checksum of its content.
+	ContentChecksum bool
+	// BlockChecksum is set if every block is followed by a checksum.
+	BlockChecksum bool
+	// ContentSize is the uncompressed size of the frame, or 0 if unknown.
+	ContentSize uint64
+	// DictID identifies the dictionary the frame was compressed with, or 0 if
+	// none was declared.
+	DictID uint32
+}
+
+func newFrameInfo(info *C.LZ4F_frameInfo_t) FrameInfo {
+	return FrameInfo{
+		BlockSize:       BlockSize(info.blockSizeID),
+		BlockMode:       BlockMode(info.blockMode),
+		ContentChecksum: info.contentChecksumFlag == C.LZ4F_contentChecksumEnabled,
+		BlockChecksum:   info.blockChecksumFlag == C.LZ4F_blockChecksumEnabled,
+		ContentSize:     uint64(info.contentSize),
+		DictID:          uint32(info.dictID),
+	}
+}
+
+// ReadFrameInfo reads and decodes an LZ4 frame header from r without
+// decompressing anything.  Exactly the bytes of the header are consumed from
+// r, so to decompress the frame afterwards the caller has to arrange for them
+// to be read again, eg. with io.MultiReader or bufio.Reader.Peek.  It fails on
+// skippable frames, which have no such header.
+func ReadFrameInfo(r io.Reader) (FrameInfo, error) {
+	// 5 bytes are enough to find out the size of the header.
+	var hdr [C.LZ4F_HEADER_SIZE_MAX]byte
+	if _, err := io.ReadFull(r, hdr[:5]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrTruncated
+		}
+		return FrameInfo{}, err
+	}
+	if binary.LittleEndian.Uint32(hdr[:])&skippableFrameMask == skippableFrameMagic {
+		return FrameInfo{}, errors.New("lz4: skippable frame has no frame info")
+	}
+	size := C.LZ4F_headerSize(unsafe.Pointer(&hdr[0]), 5)
+	if C.LZ4F_isError(size) != 0 {
+		return FrameInfo{}, frameError(size)
+	}
+	if _, err := io.ReadFull(r, hdr[5:size]); err != nil {
+		return FrameInfo{}, unexpectedEOF(err)
+	}
+
+	var ctx *C.LZ4F_dctx
+	C.LZ4F_createDecompressionContext(&ctx, C.LZ4F_VERSION)
+	defer C.LZ4F_freeDecompressionContext(ctx)
+
+	var info C.LZ4F_frameInfo_t
+	ret := C.LZ4F_getFrameInfo(ctx, &info, unsafe.Pointer(&hdr[0]), &size)
+	if C.LZ4F_isError(ret) != 0 {
+		return FrameInfo{}, frameError(ret)
+	}
+	return newFrameInfo(&info), nil
+}
+
+// frameError converts an LZ4F error code into a Go error, which wraps one of
+// the package's sentinel errors where one applies.
+func frameError(code C.size_t) error {
+	name := C.GoString(C.LZ4F_getErrorName(C.LZ4F_errorCode_t(code)))
+	var sentinel error
+	switch C.LZ4F_getErrorCode(code) {
+	case C.LZ4F_ERROR_headerChecksum_invalid, C.LZ4F_ERROR_blockChecksum_invalid, C.LZ4F_ERROR_contentChecksum_invalid:
+		sentinel = ErrChecksum
+	case C.LZ4F_ERROR_decompressionFailed, C.LZ4F_ERROR_frameType_unknown, C.LZ4F_ERROR_headerVersion_wrong, C.LZ4F_ERROR_reservedFlag_set:
+		sentinel = ErrCorrupt
+	case C.LZ4F_ERROR_dstMaxSize_tooSmall:
+		sentinel = ErrDstTooSmall
+	case C.LZ4F_ERROR_srcSize_tooLarge:
+		sentinel = ErrInputTooLarge
+	default:
+		return errors.New("lz4: " + name)
+	}
+	return fmt.Errorf("%w: %s", sentinel, name)
+}
+
+// FrameWriter is an io.WriteCloser that compresses its input into a single
+// LZ4 frame.
+type FrameWriter struct {
+	ctx              *C.LZ4F_cctx
+	prefs            C.LZ4F_preferences_t
+	cdict            *C.LZ4F_CDict
+	underlyingWriter io.Writer
+	buf              []byte
+	wroteHeader      bool
+}
+
+// NewFrameWriter creates a new FrameWriter with default options.  Writes to
+// the returned writer are compressed and written to w as an LZ4 frame.  It is
+// the caller's responsibility to call Close on the FrameWriter when done, as
+// this writes the end of the frame and frees the underlying lz4 context.
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	fw, _ := NewFrameWriterOptions(w, FrameOptions{})
+	return fw
+}
+
+// NewFrameWriterOptions is like NewFrameWriter but compresses according to
+// opts.  It returns an error if opts are invalid.
+func NewFrameWriterOptions(w io.Writer, opts FrameOptions) (*FrameWriter, error) {
+	prefs, err := opts.preferences()
+	if err != nil {
+		return nil, err
+	}
+	fw := &FrameWriter{
+		prefs:            prefs,
+		underlyingWriter: w,
+	}
+	C.LZ4F_createCompressionContext(&fw.ctx, C.LZ4F_VERSION)
+	if d := opts.Dictionary; d != nil {
+		fw.cdict = C.LZ4F_createCDict(unsafe.Pointer(p(d.data)), C.size_t(len(d.data)))
+		runtime.KeepAlive(d)
+	}
+	return fw, nil
+}
+
+// begin writes the frame header if it has not been written yet.
+func (w *FrameWriter) begin() error {
+	if w.ctx == nil {
+		return ErrClosed
+	}
+	if w.wroteHeader {
+		return nil
+	}
+	if w.buf == nil {
+		w.buf = make([]byte, int(C.LZ4F_compressBound(frameChunkSize, &w.prefs))+C.LZ4F_HEADER_SIZE_MAX)
+	}
+	n := C.LZ4F_compressBegin_usingCDict(w.ctx, unsafe.Pointer(&w.buf[0]), C.size_t(len(w.buf)), w.cdict, &w.prefs)
+	if C.LZ4F_isError(n) != 0 {
+		return frameError(n)
+	}
+	w.wroteHeader = true
+	return w.emit(n)
+}
+
+// emit writes the first n bytes of the compression buffer to the underlying
+// writer.
+func (w *FrameWriter) emit(n C.size_t) error {
+	if n == 0 {
+		return nil
+	}
+	_, err := w.underlyingWriter.Write(w.buf[:n])
+	return err
+}
+
+// Write compresses src and writes any completed blocks to the underlying
+// io.Writer.  Data may be buffered until a block is full; use Flush to force
+// it out.
+func (w *FrameWriter) Write(src []byte) (int, error) {
+	if err := w.begin(); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for len(src) > 0 {
+		chunk := src
+		if len(chunk) > frameChunkSize {
+			chunk = chunk[:frameChunkSize]
+		}
+		n := C.LZ4F_compressUpdate(w.ctx,
+			unsafe.Pointer(&w.buf[0]), C.size_t(len(w.buf)),
+			unsafe.Pointer(&chunk[0]), C.size_t(len(chunk)),
+			nil)
+		if C.LZ4F_isError(n) != 0 {
+			return written, frameError(n)
+		}
+		if err := w.emit(n); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		src = src[len(chunk):]
+	}
+	return written, nil
+}
+
+// Flush compresses any buffered data into a block and writes it to the
+// underlying io.Writer.
+func (w *FrameWriter) Flush() error {
+	if err := w.begin(); err != nil {
+		return err
+	}
+	n := C.LZ4F_flush(w.ctx, unsafe.Pointer(&w.buf[0]), C.size_t(len(w.buf)), nil)
+	if C.LZ4F_isError(n) != 0 {
+		return frameError(n)
+	}
+	return w.emit(n)
+}
+
+// Close flushes any buffered data, writes the end of the frame and releases
+// the lz4 context.  It does not close the underlying io.Writer.
+func (w *FrameWriter) Close() error {
+	if w.ctx == nil {
+		return nil
+	}
+	defer func() {
+		C.LZ4F_freeCompressionContext(w.ctx)
+		w.ctx = nil
+		if w.cdict != nil {
+			C.LZ4F_freeCDict(w.cdict)
+			w.cdict = nil
+		}
+	}()
+
+	if err := w.begin(); err != nil {
+		return err
+	}
+	n := C.LZ4F_compressEnd(w.ctx, unsafe.Pointer(&w.buf[0]), C.size_t(len(w.buf)), nil)
+	if C.LZ4F_isError(n) != 0 {
+		return frameError(n)
+	}
+	return w.emit(n)
+}
+
+// SkippableFrameFunc is called by a FrameReader for every skippable frame it
+// encounters.  id is the low 4 bits of the frame's magic number and data its
+// content.  data is only valid for the duration of the call.  A non-nil error
+// stops the FrameReader, and is returned by the Read that found the frame.
+type SkippableFrameFunc func(id int, data []byte) error
+
+const (
+	frameMagic          = 0x184D2204
+	skippableFrameMagic = 0x184D2A50
+	// skippableFrameMask selects the bits of a magic number that are common
+	// to all 16 skippable frame magic numbers.
+	skippableFrameMask = 0xFFFFFFF0
+)
+
+// WriteSkippableFrame writes data to w as a skippable frame.  id must be in
+// the inclusive range 0 through 15 and becomes the low 4 bits of the frame's
+// magic number.  Skippable frames are ignored by LZ4 decoders, but can be
+// seen with FrameReader.OnSkippableFrame.  They must not be written in the
+// middle of another frame, ie. between NewFrameWriter and Close.
+func WriteSkippableFrame(w io.Writer, id int, data []byte) error {
+	if id < 0 || id > 15 {
+		return fmt.Errorf("lz4: invalid skippable frame id %d", id)
+	}
+	if uint64(len(data)) > math.MaxUint32 {
+		return fmt.Errorf("lz4: skippable frame is too large: %d bytes", len(data))
+	}
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[:], skippableFrameMagic|uint32(id))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// FrameReader is an io.ReadCloser that decompresses a stream of LZ4 frames.
+type FrameReader struct {
+	ctx              *C.LZ4F_dctx
+	underlyingReader io.Reader
+	onSkippable      SkippableFrameFunc
+	dicts            *DictionaryRegistry
+	src              []byte
+	srcPos, srcEnd   int
+	// inFrame is set once the header of a compressed frame has been read,
+	// and cleared when that frame ends.  info is that header, and dict the
+	// dictionary the frame needs, if any.
+	inFrame bool
+	info    FrameInfo
+	dict    *Dictionary
+	eof     bool
+	// err is set if the reader cannot go on with the current frame.
+	err error
+}
+
+// NewFrameReader creates a new FrameReader.  Reads from the returned reader
+// read and decompress data from r.  Concatenated frames are decompressed one
+// after the other, and skippable frames are ignored unless a function is set
+// with OnSkippableFrame.  It is the caller's responsibility to call Close on
+// the FrameReader when done.  If this is not done, underlying objects in the
+// lz4 library will not be freed.
+func NewFrameReader(r io.Reader) *FrameReader {
+	fr := &FrameReader{
+		underlyingReader: r,
+		src:              make([]byte, frameChunkSize),
+	}
+	C.LZ4F_createDecompressionContext(&fr.ctx, C.LZ4F_VERSION)
+	return fr
+}
+
+// OnSkippableFrame sets fn to be called for every skippable frame read from
+// now on.  A nil fn ignores skippable frames.
+func (r *FrameReader) OnSkippableFrame(fn SkippableFrameFunc) {
+	r.onSkippable = fn
+}
+
+// SetDictionaries sets the registry in which the dictionaries of frames read
+// from now on are looked up.  Reading a frame that declares a dictionary ID
+// fails with ErrUnknownDictionary unless a dictionary with that ID is
+// registered.
+func (r *FrameReader) SetDictionaries(dicts *DictionaryRegistry) {
+	r.dicts = dicts
+}
+
+// Read decompresses data from the underlying io.Reader into dst.  It returns
+// io.EOF when the underlying reader ends after a complete frame, and
+// ErrTruncated if it ends in the middle of a frame.
+func (r *FrameReader) Read(dst []byte) (int, error) {
+	if r.ctx == nil {
+		return 0, ErrClosed
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(dst) == 0 {
+		return 0, nil
+	}
+
+	for {
+		if !r.inFrame {
+			if err := r.startFrame(); err != nil {
+				return 0, err
+			}
+		}
+
+		// Always give lz4frame a chance to run, even without new input, since
+		// it may still hold output that did not fit into the last dst.
+		dstSize := C.size_t(len(dst))
+		srcSize := C.size_t(r.srcEnd - r.srcPos)
+		hint := C.LZ4F_decompress_usingDict(r.ctx,
+			unsafe.Pointer(&dst[0]), &dstSize,
+			unsafe.Pointer(p(r.src[r.srcPos:r.srcEnd])), &srcSize,
+			unsafe.Pointer(p(r.dictData())), C.size_t(len(r.dictData())),
+			nil)
+		if C.LZ4F_isError(hint) != 0 {
+			return 0, frameError(hint)
+		}
+		r.srcPos += int(srcSize)
+		if hint == 0 {
+			r.inFrame = false
+		}
+		if dstSize > 0 {
+			return int(dstSize), nil
+		}
+		if srcSize > 0 || !r.inFrame {
+			continue
+		}
+
+		if err := r.fill(); err != nil {
+			return 0, unexpectedEOF(err)
+		}
+	}
+}
+
+// FrameInfo returns the header of the frame being read.  If the reader is
+// between frames, the header of the next frame is read from the underlying
+// io.Reader but no data is decompressed.
+func (r *FrameReader) FrameInfo() (FrameInfo, error) {
+	if r.ctx == nil {
+		return FrameInfo{}, ErrClosed
+	}
+	if r.err != nil {
+		return FrameInfo{}, r.err
+	}
+	if !r.inFrame {
+		if err := r.startFrame(); err != nil {
+			return FrameInfo{}, err
+		}
+	}
+	return r.info, nil
+}
+
+// startFrame reads the header of the next compressed frame, and looks up the
+// dictionary it declares, if any.
+func (r *FrameReader) startFrame() error {
+	if err := r.nextFrame(); err != nil {
+		return err
+	}
+	for {
+		var info C.LZ4F_frameInfo_t
+		srcSize := C.size_t(r.srcEnd - r.srcPos)
+		ret := C.LZ4F_getFrameInfo(r.ctx, &info, unsafe.Pointer(p(r.src[r.srcPos:r.srcEnd])), &srcSize)
+		if C.LZ4F_isError(ret) == 0 {
+			r.srcPos += int(srcSize)
+			r.info = newFrameInfo(&info)
+			break
+		}
+		if C.LZ4F_getErrorCode(ret) != C.LZ4F_ERROR_frameHeader_incomplete {
+			return frameError(ret)
+		}
+		if err := r.fill(); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+	r.inFrame = true
+
+	r.dict = nil
+	if r.info.DictID != 0 {
+		dict := r.dicts.Lookup(r.info.DictID)
+		if dict == nil {
+			// The frame cannot be decompressed, nor skipped.
+			r.err = fmt.Errorf("%w %d", ErrUnknownDictionary, r.info.DictID)
+			return r.err
+		}
+		r.dict = dict
+	}
+	return nil
+}
+
+// dictData returns the dictionary of the current frame, or nil if it has
+// none.
+func (r *FrameReader) dictData() []byte {
+	if r.dict == nil {
+		return nil
+	}
+	return r.dict.data
+}
+
+// nextFrame positions the reader at the start of the next compressed frame,
+// handing any skippable frames on the way to onSkippable.  It returns io.EOF
+// if the underlying io.Reader ends before another frame starts.
+func (r *FrameReader) nextFrame() error {
+	for {
+		for r.srcEnd-r.srcPos < 4 {
+			if err := r.fill(); err != nil {
+				if err == io.EOF && r.srcPos < r.srcEnd {
+					return ErrTruncated
+				}
+				return err
+			}
+		}
+		magic := binary.LittleEndian.Uint32(r.src[r.srcPos:])
+		if magic&skippableFrameMask != skippableFrameMagic {
+			return nil
+		}
+
+		for r.srcEnd-r.srcPos < 8 {
+			if err := r.fill(); err != nil {
+				return unexpectedEOF(err)
+			}
+		}
+		size := int64(binary.LittleEndian.Uint32(r.src[r.srcPos+4:]))
+		r.srcPos += 8
+		if err := r.skippableFrame(int(magic&^skippableFrameMask), size); err != nil {
+			return err
+		}
+	}
+}
+
+// skippableFrame consumes the size bytes of content of a skippable frame and
+// passes them to onSkippable.
+func (r *FrameReader) skippableFrame(id int, size int64) error {
+	buffered := int64(r.srcEnd - r.srcPos)
+	if buffered > size {
+		buffered = size
+	}
+	if r.onSkippable == nil {
+		r.srcPos += int(buffered)
+		_, err := io.CopyN(ioutil.Discard, r.underlyingReader, size-buffered)
+		return unexpectedEOF(err)
+	}
+
+	data := make([]byte, size)
+	r.srcPos += copy(data, r.src[r.srcPos:r.srcPos+int(buffered)])
+	if _, err := io.ReadFull(r.underlyingReader, data[buffered:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	return r.onSkippable(id, data)
+}
+
+// fill reads more compressed data from the underlying io.Reader, keeping any
+// input that has not been consumed yet.  It returns io.EOF once the
+// underlying reader is exhausted.
+func (r *FrameReader) fill() error {
+	if r.eof {
+		return io.EOF
+	}
+	if r.srcPos > 0 {
+		r.srcEnd = copy(r.src, r.src[r.srcPos:r.srcEnd])
+		r.srcPos = 0
+	}
+	n, err := r.underlyingReader.Read(r.src[r.srcEnd:])
+	r.srcEnd += n
+	if err == io.EOF {
+		r.eof = true
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// unexpectedEOF turns io.EOF and io.ErrUnexpectedEOF into ErrTruncated, for
+// use where the underlying reader ends in the middle of a frame.
+func unexpectedEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+// Close releases all the resources occupied by r.  It does not close the
+// underlying io.Reader.
+func (r *FrameReader) Close() error {
+	if r.ctx != nil {
+		C.LZ4F_freeDecompressionContext(r.ctx)
+		r.ctx = nil
+	}
+	return nil
+}
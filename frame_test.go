@@ -0,0 +1,489 @@
+package lz4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func compressFrame(t *testing.T, input []byte) []byte {
+	var buf bytes.Buffer
+	w := NewFrameWriter(&buf)
+	_, err := w.Write(input)
+	failOnError(t, "Failed writing to frame writer", err)
+	failOnError(t, "Failed closing frame writer", w.Close())
+	return buf.Bytes()
+}
+
+func TestFrameCompressDecompress(t *testing.T) {
+	input, err := ioutil.ReadFile("sample.txt")
+	failOnError(t, "Failed reading sample", err)
+
+	compressed := compressFrame(t, input)
+	if magic := binary.LittleEndian.Uint32(compressed); magic != 0x184D2204 {
+		t.Fatalf("Frame magic number != expected: %#x != %#x", magic, 0x184D2204)
+	}
+	t.Logf("Compressed %v -> %v bytes", len(input), len(compressed))
+
+	r := NewFrameReader(bytes.NewReader(compressed))
+	defer r.Close()
+	output, err := ioutil.ReadAll(r)
+	failOnError(t, "Failed reading frame", err)
+	if !bytes.Equal(input, output) {
+		t.Fatalf("Decompressed output != input (lengths: %v bytes & %v bytes)", len(output), len(input))
+	}
+}
+
+func TestFrameEmpty(t *testing.T) {
+	compressed := compressFrame(t, nil)
+	if len(compressed) == 0 {
+		t.Fatal("Empty input should still produce a frame")
+	}
+
+	r := NewFrameReader(bytes.NewReader(compressed))
+	defer r.Close()
+	output, err := ioutil.ReadAll(r)
+	failOnError(t, "Failed reading frame", err)
+	if len(output) != 0 {
+		t.Fatalf("Expected no output, got %d bytes", len(output))
+	}
+}
+
+func TestFrameLargeWriteSmallReads(t *testing.T) {
+	input := []byte(strings.Repeat("Hello world, this is quite something. ", 20000))
+	compressed := compressFrame(t, input)
+
+	r := NewFrameReader(bytes.NewReader(compressed))
+	defer r.Close()
+	var output bytes.Buffer
+	dst := make([]byte, 7)
+	for {
+		n, err := r.Read(dst)
+		output.Write(dst[:n])
+		if err == io.EOF {
+			break
+		}
+		failOnError(t, "Failed reading frame", err)
+	}
+	if !bytes.Equal(input, output.Bytes()) {
+		t.Fatalf("Decompressed output != input (lengths: %v bytes & %v bytes)", output.Len(), len(input))
+	}
+}
+
+func TestFrameFlush(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewFrameWriter(&buf)
+	defer w.Close()
+	_, err := w.Write([]byte("flushed data"))
+	failOnError(t, "Failed writing to frame writer", err)
+	failOnError(t, "Failed flushing frame writer", w.Flush())
+
+	r := NewFrameReader(&buf)
+	defer r.Close()
+	dst := make([]byte, 64)
+	n, err := r.Read(dst)
+	failOnError(t, "Failed reading flushed data", err)
+	if string(dst[:n]) != "flushed data" {
+		t.Fatalf("Flushed data != expected: %q", dst[:n])
+	}
+}
+
+func TestFrameTruncated(t *testing.T) {
+	input := []byte(strings.Repeat("Hello world, this is quite something", 100))
+	compressed := compressFrame(t, input)
+
+	r := NewFrameReader(bytes.NewReader(compressed[:len(compressed)-2]))
+	defer r.Close()
+	_, err := ioutil.ReadAll(r)
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Error should have been ErrTruncated, was %v instead", err)
+	}
+}
+
+func TestFrameCorrupt(t *testing.T) {
+	r := NewFrameReader(bytes.NewReader([]byte("this is not an lz4 frame")))
+	defer r.Close()
+	_, err := ioutil.ReadAll(r)
+	if err == nil {
+		t.Fatal("Reading garbage should have failed")
+	}
+}
+
+func TestFrameOptions(t *testing.T) {
+	input, err := ioutil.ReadFile("sample.txt")
+	failOnError(t, "Failed reading sample", err)
+	input = bytes.Repeat(input, 100)
+
+	cases := []FrameOptions{
+		{},
+		{BlockSize: BlockSize256KB},
+		{BlockSize: BlockSize1MB, BlockMode: BlockIndependent},
+		{BlockSize: BlockSize4MB, ContentChecksum: true},
+		{BlockChecksum: true, BlockMode: BlockIndependent},
+		{ContentSize: uint64(len(input)), ContentChecksum: true},
+		{CompressionLevel: 9},
+		{CompressionLevel: 16, BlockMode: BlockIndependent},
+		{CompressionLevel: -10},
+		{AutoFlush: true},
+	}
+
+	for _, opts := range cases {
+		var buf bytes.Buffer
+		w, err := NewFrameWriterOptions(&buf, opts)
+		failOnError(t, "Failed creating frame writer", err)
+		_, err = w.Write(input)
+		failOnError(t, "Failed writing to frame writer", err)
+		failOnError(t, "Failed closing frame writer", w.Close())
+
+		// FLG byte: block independence, block checksum, content size and
+		// content checksum flags.  BD byte: block maximum size.
+		flg, bd := buf.Bytes()[4], buf.Bytes()[5]
+		if got := flg&0x20 != 0; got != (opts.BlockMode == BlockIndependent) {
+			t.Errorf("%+v: block independence flag = %v", opts, got)
+		}
+		if got := flg&0x10 != 0; got != opts.BlockChecksum {
+			t.Errorf("%+v: block checksum flag = %v", opts, got)
+		}
+		if got := flg&0x08 != 0; got != (opts.ContentSize != 0) {
+			t.Errorf("%+v: content size flag = %v", opts, got)
+		}
+		if got := flg&0x04 != 0; got != opts.ContentChecksum {
+			t.Errorf("%+v: content checksum flag = %v", opts, got)
+		}
+		wantBD := opts.BlockSize
+		if wantBD == BlockSizeDefault {
+			wantBD = BlockSize64KB
+		}
+		if got := BlockSize(bd >> 4); got != wantBD {
+			t.Errorf("%+v: block size = %d, want %d", opts, got, wantBD)
+		}
+
+		r := NewFrameReader(&buf)
+		output, err := ioutil.ReadAll(r)
+		failOnError(t, "Failed reading frame", err)
+		r.Close()
+		if !bytes.Equal(input, output) {
+			t.Errorf("%+v: decompressed output != input (lengths: %v bytes & %v bytes)", opts, len(output), len(input))
+		}
+	}
+}
+
+func TestFrameOptionsHCRatio(t *testing.T) {
+	input := []byte(strings.Repeat("Hello world, this is quite something. 0123456789 ", 5000))
+	size := func(level int) int {
+		var buf bytes.Buffer
+		w, err := NewFrameWriterOptions(&buf, FrameOptions{CompressionLevel: level})
+		failOnError(t, "Failed creating frame writer", err)
+		_, err = w.Write(input)
+		failOnError(t, "Failed writing to frame writer", err)
+		failOnError(t, "Failed closing frame writer", w.Close())
+		return buf.Len()
+	}
+	if fast, hc := size(0), size(16); hc > fast {
+		t.Fatalf("HC frame larger than fast frame: %d > %d", hc, fast)
+	}
+}
+
+func TestFrameOptionsInvalid(t *testing.T) {
+	if _, err := NewFrameWriterOptions(ioutil.Discard, FrameOptions{BlockSize: 3}); err == nil {
+		t.Fatal("Invalid block size should have failed")
+	}
+	if _, err := NewFrameWriterOptions(ioutil.Discard, FrameOptions{BlockMode: 2}); err == nil {
+		t.Fatal("Invalid block mode should have failed")
+	}
+}
+
+func TestFrameContentSizeMismatch(t *testing.T) {
+	w, err := NewFrameWriterOptions(ioutil.Discard, FrameOptions{ContentSize: 100})
+	failOnError(t, "Failed creating frame writer", err)
+	_, err = w.Write([]byte("too short"))
+	failOnError(t, "Failed writing to frame writer", err)
+	if err := w.Close(); err == nil {
+		t.Fatal("Closing with a wrong content size should have failed")
+	}
+}
+
+func TestReadFrameInfo(t *testing.T) {
+	input := []byte(strings.Repeat("Hello world, this is quite something", 100))
+	opts := FrameOptions{
+		BlockSize:       BlockSize1MB,
+		BlockMode:       BlockIndependent,
+		ContentChecksum: true,
+		BlockChecksum:   true,
+		ContentSize:     uint64(len(input)),
+	}
+	var buf bytes.Buffer
+	w, err := NewFrameWriterOptions(&buf, opts)
+	failOnError(t, "Failed creating frame writer", err)
+	_, err = w.Write(input)
+	failOnError(t, "Failed writing to frame writer", err)
+	failOnError(t, "Failed closing frame writer", w.Close())
+	compressed := buf.Bytes()
+
+	want := FrameInfo{
+		BlockSize:       BlockSize1MB,
+		BlockMode:       BlockIndependent,
+		ContentChecksum: true,
+		BlockChecksum:   true,
+		ContentSize:     uint64(len(input)),
+	}
+	rdr := bytes.NewReader(compressed)
+	info, err := ReadFrameInfo(rdr)
+	failOnError(t, "Failed reading frame info", err)
+	if info != want {
+		t.Fatalf("Frame info != expected: %+v != %+v", info, want)
+	}
+	// magic, FLG, BD, content size and header checksum
+	if consumed := len(compressed) - rdr.Len(); consumed != 15 {
+		t.Fatalf("ReadFrameInfo consumed %d bytes, want 15", consumed)
+	}
+
+	// FrameReader.FrameInfo does not get in the way of reading the frame.
+	r := NewFrameReader(bytes.NewReader(compressed))
+	defer r.Close()
+	info, err = r.FrameInfo()
+	failOnError(t, "Failed reading frame info", err)
+	if info != want {
+		t.Fatalf("Frame info != expected: %+v != %+v", info, want)
+	}
+	output, err := ioutil.ReadAll(r)
+	failOnError(t, "Failed reading frame", err)
+	if !bytes.Equal(input, output) {
+		t.Fatalf("Decompressed output != input (lengths: %v bytes & %v bytes)", len(output), len(input))
+	}
+}
+
+func TestReadFrameInfoDefaults(t *testing.T) {
+	info, err := ReadFrameInfo(bytes.NewReader(compressFrame(t, []byte("hello"))))
+	failOnError(t, "Failed reading frame info", err)
+	if want := (FrameInfo{BlockSize: BlockSize64KB}); info != want {
+		t.Fatalf("Frame info != expected: %+v != %+v", info, want)
+	}
+}
+
+func TestReadFrameInfoErrors(t *testing.T) {
+	if _, err := ReadFrameInfo(bytes.NewReader(nil)); err != io.EOF {
+		t.Fatalf("Error should have been EOF, was %v instead", err)
+	}
+	if _, err := ReadFrameInfo(bytes.NewReader([]byte("not an lz4 frame"))); err == nil {
+		t.Fatal("Reading the header of garbage should have failed")
+	}
+	compressed := compressFrame(t, []byte("hello"))
+	if _, err := ReadFrameInfo(bytes.NewReader(compressed[:6])); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Error should have been ErrTruncated, was %v instead", err)
+	}
+
+	r := NewFrameReader(bytes.NewReader(compressed[:6]))
+	defer r.Close()
+	if _, err := r.FrameInfo(); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Error should have been ErrTruncated, was %v instead", err)
+	}
+}
+
+func TestFrameConcatenated(t *testing.T) {
+	first := []byte(strings.Repeat("first frame ", 1000))
+	second := []byte(strings.Repeat("second frame ", 1000))
+	compressed := append(compressFrame(t, first), compressFrame(t, second)...)
+
+	r := NewFrameReader(bytes.NewReader(compressed))
+	defer r.Close()
+	output, err := ioutil.ReadAll(r)
+	failOnError(t, "Failed reading frames", err)
+	if want := append(first, second...); !bytes.Equal(want, output) {
+		t.Fatalf("Decompressed output != input (lengths: %v bytes & %v bytes)", len(output), len(want))
+	}
+}
+
+func TestFrameSkippable(t *testing.T) {
+	var buf bytes.Buffer
+	failOnError(t, "Failed writing skippable frame", WriteSkippableFrame(&buf, 0, []byte("host=a")))
+	buf.Write(compressFrame(t, []byte("first")))
+	failOnError(t, "Failed writing skippable frame", WriteSkippableFrame(&buf, 15, nil))
+	failOnError(t, "Failed writing skippable frame", WriteSkippableFrame(&buf, 7, bytes.Repeat([]byte("x"), 100000)))
+	buf.Write(compressFrame(t, []byte("second")))
+	failOnError(t, "Failed writing skippable frame", WriteSkippableFrame(&buf, 1, []byte("trailer")))
+	compressed := buf.Bytes()
+
+	type skippable struct {
+		id   int
+		size int
+	}
+	var seen []skippable
+	r := NewFrameReader(bytes.NewReader(compressed))
+	defer r.Close()
+	r.OnSkippableFrame(func(id int, data []byte) error {
+		seen = append(seen, skippable{id, len(data)})
+		return nil
+	})
+	output, err := ioutil.ReadAll(r)
+	failOnError(t, "Failed reading frames", err)
+	if string(output) != "firstsecond" {
+		t.Fatalf("Decompressed output != expected: %q", output)
+	}
+	want := []skippable{{0, 6}, {15, 0}, {7, 100000}, {1, 7}}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Fatalf("Skippable frames != expected: %v != %v", seen, want)
+	}
+
+	// Without a handler skippable frames are ignored.
+	r = NewFrameReader(bytes.NewReader(compressed))
+	defer r.Close()
+	output, err = ioutil.ReadAll(r)
+	failOnError(t, "Failed reading frames", err)
+	if string(output) != "firstsecond" {
+		t.Fatalf("Decompressed output != expected: %q", output)
+	}
+}
+
+func TestFrameSkippableErrors(t *testing.T) {
+	if err := WriteSkippableFrame(ioutil.Discard, 16, nil); err == nil {
+		t.Fatal("Invalid skippable frame id should have failed")
+	}
+
+	var buf bytes.Buffer
+	failOnError(t, "Failed writing skippable frame", WriteSkippableFrame(&buf, 0, []byte("metadata")))
+	buf.Write(compressFrame(t, []byte("data")))
+	compressed := buf.Bytes()
+
+	handlerErr := errors.New("rejected")
+	r := NewFrameReader(bytes.NewReader(compressed))
+	defer r.Close()
+	r.OnSkippableFrame(func(id int, data []byte) error { return handlerErr })
+	if _, err := ioutil.ReadAll(r); err != handlerErr {
+		t.Fatalf("Error should have been the handler's, was %v instead", err)
+	}
+
+	r = NewFrameReader(bytes.NewReader(compressed[:10]))
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Error should have been ErrTruncated, was %v instead", err)
+	}
+
+	if _, err := ReadFrameInfo(bytes.NewReader(compressed)); err == nil {
+		t.Fatal("ReadFrameInfo on a skippable frame should have failed")
+	}
+}
+
+func TestFrameDictionary(t *testing.T) {
+	dicts := NewDictionaryRegistry()
+	failOnError(t, "Failed registering dictionary", dicts.Register(1, NewDictionary([]byte("unrelated data"))))
+	failOnError(t, "Failed registering dictionary", dicts.Register(2, jsonDictionary()))
+	input := append(jsonEvent(5), jsonEvent(6)...)
+	plain := compressFrame(t, input)
+
+	for _, opts := range []FrameOptions{
+		{},
+		{BlockMode: BlockIndependent},
+		{CompressionLevel: 9, ContentChecksum: true},
+	} {
+		opts.Dictionary = dicts.Lookup(2)
+		opts.DictID = 2
+		var buf bytes.Buffer
+		w, err := NewFrameWriterOptions(&buf, opts)
+		failOnError(t, "Failed creating frame writer", err)
+		_, err = w.Write(input)
+		failOnError(t, "Failed writing to frame writer", err)
+		failOnError(t, "Failed closing frame writer", w.Close())
+		if buf.Len() >= len(plain)/2 {
+			t.Errorf("%+v: dictionary should have helped: %d bytes vs %d without", opts, buf.Len(), len(plain))
+		}
+
+		r := NewFrameReader(bytes.NewReader(buf.Bytes()))
+		r.SetDictionaries(dicts)
+		info, err := r.FrameInfo()
+		failOnError(t, "Failed reading frame info", err)
+		if info.DictID != 2 {
+			t.Errorf("%+v: frame DictID != expected: %d != 2", opts, info.DictID)
+		}
+		output, err := ioutil.ReadAll(r)
+		r.Close()
+		failOnError(t, "Failed reading frame", err)
+		if !bytes.Equal(input, output) {
+			t.Fatalf("%+v: decompressed output != input: %q", opts, output)
+		}
+	}
+}
+
+func TestFrameDictionaryUnknown(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewFrameWriterOptions(&buf, FrameOptions{Dictionary: jsonDictionary(), DictID: 42})
+	failOnError(t, "Failed creating frame writer", err)
+	_, err = w.Write(jsonEvent(7))
+	failOnError(t, "Failed writing to frame writer", err)
+	failOnError(t, "Failed closing frame writer", w.Close())
+
+	dicts := NewDictionaryRegistry()
+	failOnError(t, "Failed registering dictionary", dicts.Register(41, jsonDictionary()))
+	for _, dicts := range []*DictionaryRegistry{nil, dicts} {
+		r := NewFrameReader(bytes.NewReader(buf.Bytes()))
+		r.SetDictionaries(dicts)
+		_, err := ioutil.ReadAll(r)
+		if !errors.Is(err, ErrUnknownDictionary) || !strings.Contains(err.Error(), "42") {
+			t.Fatalf("Reading a frame with an unknown dictionary should have failed, error was %v", err)
+		}
+		if _, err2 := r.Read(make([]byte, 10)); err2 != err {
+			t.Fatalf("Error should have stuck, was %v instead", err2)
+		}
+		r.Close()
+	}
+
+	for _, opts := range []FrameOptions{{DictID: 1}, {Dictionary: jsonDictionary()}} {
+		if _, err := NewFrameWriterOptions(ioutil.Discard, opts); err == nil {
+			t.Errorf("Options should have been rejected: %+v", opts)
+		}
+	}
+}
+
+func TestFrameFuzz(t *testing.T) {
+	f := func(input []byte) bool {
+		compressed := compressFrame(t, input)
+		r := NewFrameReader(bytes.NewReader(compressed))
+		defer r.Close()
+		output, err := ioutil.ReadAll(r)
+		failOnError(t, "Failed reading frame", err)
+		return bytes.Equal(input, output)
+	}
+
+	conf := &quick.Config{MaxCount: 1000}
+	if testing.Short() {
+		conf.MaxCount = 100
+	}
+	if err := quick.Check(f, conf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFramePythonInterop(t *testing.T) {
+	if !pymod("lz4.frame") {
+		t.Log("Warning: not testing python module compat: no module lz4.frame found")
+		t.Skip()
+		return
+	}
+
+	corpus, err := ioutil.ReadFile("sample.txt")
+	failOnError(t, "Failed reading sample", err)
+
+	dst := "/tmp/lz4frametest.lz4"
+	err = ioutil.WriteFile(dst, compressFrame(t, corpus), 0644)
+	failOnError(t, "Failed writing frame", err)
+	defer os.Remove(dst)
+
+	var out bytes.Buffer
+	cmd := exec.Command("python", "-c", fmt.Sprintf(`import lz4.frame, sys; sys.stdout.write(str(len(lz4.frame.decompress(open("%s", "rb").read()))))`, dst))
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		t.Fatal(out.String())
+	}
+	if want := fmt.Sprint(len(corpus)); out.String() != want {
+		t.Fatalf("Expected length %s, got %s", want, out.String())
+	}
+}
@@ -6,8 +6,13 @@ package lz4
 
 import (
 	"encoding/binary"
+	"fmt"
 )
 
+// errPageTooSmall is returned by CompressPagesHdr when not even one byte of
+// input fits into a page.
+var errPageTooSmall = fmt.Errorf("%w: page size is too small", ErrDstTooSmall)
+
 // CompressBoundHdr returns the upper bounds of the size of the compressed
 // byte plus space for a length header.
 func CompressBoundHdr(in []byte) int {
@@ -40,6 +45,9 @@ func CompressAllocHdr(in []byte) (out []byte, err error) {
 // UncompressHdr uncompresses in into out.  Out must have enough space allocated
 // for the uncompressed message.
 func UncompressHdr(out, in []byte) error {
+	if len(in) < 4 {
+		return ErrTruncated
+	}
 	_, err := Uncompress(out, in[4:])
 	return err
 }
@@ -50,6 +58,9 @@ func UncompressHdr(out, in []byte) error {
 // necessary fo the result message, which CloudFlare's implementation doesn't
 // have.
 func UncompressAllocHdr(out, in []byte) ([]byte, error) {
+	if len(in) < 4 {
+		return out, ErrTruncated
+	}
 	origlen := binary.LittleEndian.Uint32(in)
 	if origlen > uint32(len(out)) {
 		out = make([]byte, origlen)
@@ -58,6 +69,37 @@ func UncompressAllocHdr(out, in []byte) ([]byte, error) {
 	return out, err
 }
 
+// AppendCompressHdr is like AppendCompress, but uses the format of CompressHdr.
+func AppendCompressHdr(dst, src []byte) ([]byte, error) {
+	bound := CompressBoundHdr(src)
+	dst = grow(dst, bound)
+	n, err := CompressHdr(dst[len(dst):len(dst)+bound], src)
+	if err != nil {
+		return dst, err
+	}
+	return dst[:len(dst)+n], nil
+}
+
+// AppendUncompressHdr decompresses src, compressed by CompressHdr, and
+// appends the result to dst, growing dst as needed, and returns the extended
+// slice.  The length header tells how much room is needed.
+func AppendUncompressHdr(dst, src []byte) ([]byte, error) {
+	if len(src) < 4 {
+		return dst, ErrTruncated
+	}
+	if len(src) == 4 {
+		// Even an empty input compresses to a byte.
+		return dst, ErrCorrupt
+	}
+	origlen := int(binary.LittleEndian.Uint32(src))
+	dst = grow(dst, origlen)
+	n, err := Uncompress(dst[len(dst):len(dst)+origlen], src[4:])
+	if err != nil {
+		return dst, err
+	}
+	return dst[:len(dst)+n], nil
+}
+
 // CompressHCHdr implements high-compression ratio compression.
 func CompressHCHdr(out, in []byte) (count int, err error) {
 	count, err = CompressHC(out[4:], in)
@@ -71,3 +113,28 @@ func CompressHCLevelHdr(out, in []byte, level int) (count int, err error) {
 	binary.LittleEndian.PutUint32(out, uint32(len(in)))
 	return count + 4, err
 }
+
+// CompressPagesHdr compresses in into a sequence of pages of at most pageSize
+// bytes each, for storage in fixed size disk pages or datagrams.  Every page
+// but the last is filled as much as possible.  Each page carries a length
+// header and can be decompressed on its own with UncompressAllocHdr, to the
+// next part of in.
+func CompressPagesHdr(in []byte, pageSize int) (pages [][]byte, err error) {
+	if pageSize <= 4 {
+		return nil, errPageTooSmall
+	}
+	for len(in) > 0 {
+		page := make([]byte, pageSize)
+		consumed, written, err := CompressDestSize(page[4:], in)
+		if err != nil {
+			return pages, err
+		}
+		if consumed == 0 {
+			return pages, errPageTooSmall
+		}
+		binary.LittleEndian.PutUint32(page, uint32(consumed))
+		pages = append(pages, page[:4+written])
+		in = in[consumed:]
+	}
+	return pages, nil
+}
@@ -71,6 +71,80 @@ func TestEmptyCompressionHdr(t *testing.T) {
 	}
 }
 
+func TestAppendCompressHdr(t *testing.T) {
+	input := []byte(strings.Repeat("Hello world, this is quite something", 10))
+	prefix := []byte("prefix")
+
+	compressed, err := AppendCompressHdr(prefix, input)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if !bytes.HasPrefix(compressed, prefix) {
+		t.Fatalf("AppendCompressHdr did not keep dst: %q", compressed)
+	}
+	decompressed, err := UncompressAllocHdr(nil, compressed[len(prefix):])
+	if err != nil || !bytes.Equal(decompressed, input) {
+		t.Fatalf("UncompressAllocHdr returned %q and error %v", decompressed, err)
+	}
+
+	output, err := AppendUncompressHdr(prefix, compressed[len(prefix):])
+	if err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if string(output) != string(prefix)+string(input) {
+		t.Fatalf("AppendUncompressHdr output != dst followed by input: %q", output)
+	}
+
+	if _, err := AppendUncompressHdr(nil, compressed[len(prefix):len(prefix)+3]); err == nil {
+		t.Fatalf("Decompression of a truncated header should have failed but didn't")
+	}
+	if _, err := AppendUncompressHdr(nil, compressed[len(prefix):len(prefix)+4]); err != ErrCorrupt {
+		t.Fatalf("Decompression of a header without a block should have failed with ErrCorrupt, got %v", err)
+	}
+}
+
+func TestCompressPagesHdr(t *testing.T) {
+	sample, err := ioutil.ReadFile("sample.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := bytes.Repeat(sample, 20)
+
+	for _, pageSize := range []int{64, 512, 4096, len(input)} {
+		pages, err := CompressPagesHdr(input, pageSize)
+		if err != nil {
+			t.Fatalf("Compression into %d byte pages failed: %v", pageSize, err)
+		}
+
+		var output []byte
+		for i, page := range pages {
+			if len(page) > pageSize {
+				t.Fatalf("Page %d is larger than %d bytes: %d", i, pageSize, len(page))
+			}
+			if i < len(pages)-1 && len(page) < pageSize-16 {
+				t.Errorf("Page %d of %d bytes should have been filled, holds %d", i, pageSize, len(page))
+			}
+			decompressed, err := UncompressAllocHdr(nil, page)
+			if err != nil {
+				t.Fatalf("Decompression of page %d failed: %v", i, err)
+			}
+			output = append(output, decompressed...)
+		}
+		if !bytes.Equal(output, input) {
+			t.Fatalf("Page size %d: decompressed output != input (lengths: %v bytes & %v bytes)", pageSize, len(output), len(input))
+		}
+	}
+
+	if pages, err := CompressPagesHdr(nil, 4096); err != nil || len(pages) != 0 {
+		t.Fatalf("Empty input should give no pages, got %d pages and error %v", len(pages), err)
+	}
+	for _, pageSize := range []int{4, 5} {
+		if _, err := CompressPagesHdr(input, pageSize); !errors.Is(err, ErrDstTooSmall) {
+			t.Fatalf("Compression into %d byte pages should have failed with ErrDstTooSmall, got %v", pageSize, err)
+		}
+	}
+}
+
 // test python interoperability
 
 // pymod returns whether or not a python module is importable.  For checking
@@ -0,0 +1,200 @@
+package lz4
+
+// legacy.go implements the legacy LZ4 format, as written by `lz4 -l`: a magic
+// number followed by independent blocks of 8MB, each prefixed with its
+// compressed size.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	legacyMagic     = 0x184C2102
+	legacyBlockSize = 8 * 1024 * 1024
+)
+
+var errLegacyMagic = fmt.Errorf("%w: not a legacy lz4 stream", ErrCorrupt)
+
+// LegacyWriter is an io.WriteCloser that compresses its input into the legacy
+// LZ4 format.
+type LegacyWriter struct {
+	underlyingWriter io.Writer
+	buf              []byte
+	compressed       []byte
+	wroteMagic       bool
+	closed           bool
+}
+
+// NewLegacyWriter creates a new LegacyWriter.  Writes to the returned writer
+// are compressed and written to w.  Input is buffered into 8MB blocks, so the
+// caller must call Close to write out the last one.
+func NewLegacyWriter(w io.Writer) *LegacyWriter {
+	return &LegacyWriter{underlyingWriter: w}
+}
+
+// Write buffers src and compresses every block of 8MB that is completed.
+func (w *LegacyWriter) Write(src []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if w.buf == nil {
+		w.buf = make([]byte, 0, legacyBlockSize)
+	}
+
+	written := 0
+	for len(src) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], src)
+		w.buf = w.buf[:len(w.buf)+n]
+		written += n
+		src = src[n:]
+		if len(w.buf) == legacyBlockSize {
+			if err := w.writeBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// writeBlock compresses the buffered input and writes it as a block.
+func (w *LegacyWriter) writeBlock() error {
+	if !w.wroteMagic {
+		var magic [4]byte
+		binary.LittleEndian.PutUint32(magic[:], legacyMagic)
+		if _, err := w.underlyingWriter.Write(magic[:]); err != nil {
+			return err
+		}
+		w.wroteMagic = true
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	if w.compressed == nil {
+		w.compressed = make([]byte, 4+CompressBoundInt(legacyBlockSize))
+	}
+	n, err := Compress(w.compressed[4:], w.buf)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(w.compressed, uint32(n))
+	if _, err := w.underlyingWriter.Write(w.compressed[:4+n]); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close compresses and writes any buffered input.  It does not close the
+// underlying io.Writer.
+func (w *LegacyWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.writeBlock()
+}
+
+// LegacyReader is an io.Reader that decompresses the legacy LZ4 format.
+type LegacyReader struct {
+	underlyingReader io.Reader
+	compressed       []byte
+	buf              []byte
+	pos              int
+	readMagic        bool
+	// block is the index of the next block, and offset where it starts in
+	// the compressed stream.
+	block  int
+	offset int64
+}
+
+// NewLegacyReader creates a new LegacyReader.  Reads from the returned reader
+// read and decompress data from r.
+func NewLegacyReader(r io.Reader) *LegacyReader {
+	return &LegacyReader{underlyingReader: r}
+}
+
+// Read decompresses data from the underlying io.Reader into dst.  Errors in
+// the stream are returned as a *StreamError.
+func (r *LegacyReader) Read(dst []byte) (int, error) {
+	for r.pos == len(r.buf) {
+		if err := r.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(dst, r.buf[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// readBlock reads and decompresses the next block into r.buf.
+func (r *LegacyReader) readBlock() error {
+	var header [4]byte
+	if !r.readMagic {
+		if _, err := io.ReadFull(r.underlyingReader, header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = errLegacyMagic
+			}
+			return err
+		}
+		if binary.LittleEndian.Uint32(header[:]) != legacyMagic {
+			return errLegacyMagic
+		}
+		r.readMagic = true
+		r.offset += 4
+	}
+
+	if _, err := io.ReadFull(r.underlyingReader, header[:]); err != nil {
+		if err == io.EOF {
+			return err
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = ErrTruncated
+		}
+		return r.blockError(err)
+	}
+	size := int(binary.LittleEndian.Uint32(header[:]))
+	if size == legacyMagic {
+		// Concatenated legacy streams repeat the magic number.
+		r.buf, r.pos = r.buf[:0], 0
+		r.offset += 4
+		return nil
+	}
+	if size == 0 {
+		return r.blockError(fmt.Errorf("%w: empty legacy block", ErrCorrupt))
+	}
+	if size > CompressBoundInt(legacyBlockSize) {
+		return r.blockError(fmt.Errorf("%w: legacy block is too large: %d bytes", ErrCorrupt, size))
+	}
+
+	if r.compressed == nil {
+		r.compressed = make([]byte, CompressBoundInt(legacyBlockSize))
+		r.buf = make([]byte, legacyBlockSize)
+	}
+	if _, err := io.ReadFull(r.underlyingReader, r.compressed[:size]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrTruncated
+		}
+		return r.blockError(err)
+	}
+	n, err := Uncompress(r.buf[:legacyBlockSize], r.compressed[:size])
+	if err != nil {
+		if err == ErrDstTooSmall {
+			// Blocks are never larger than legacyBlockSize.
+			err = ErrCorrupt
+		}
+		return r.blockError(err)
+	}
+	r.buf, r.pos = r.buf[:n], 0
+	r.block++
+	r.offset += int64(4 + size)
+	return nil
+}
+
+// blockError wraps err, which happened while reading the next block, in a
+// *StreamError.
+func (r *LegacyReader) blockError(err error) error {
+	return &StreamError{Block: r.block, Offset: r.offset, Err: err}
+}
@@ -0,0 +1,118 @@
+package lz4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func compressLegacy(t *testing.T, input []byte) []byte {
+	var buf bytes.Buffer
+	w := NewLegacyWriter(&buf)
+	_, err := w.Write(input)
+	failOnError(t, "Failed writing to legacy writer", err)
+	failOnError(t, "Failed closing legacy writer", w.Close())
+	return buf.Bytes()
+}
+
+func TestLegacyCompressDecompress(t *testing.T) {
+	input, err := ioutil.ReadFile("sample.txt")
+	failOnError(t, "Failed reading sample", err)
+
+	compressed := compressLegacy(t, input)
+	if magic := binary.LittleEndian.Uint32(compressed); magic != 0x184C2102 {
+		t.Fatalf("Legacy magic number != expected: %#x != %#x", magic, 0x184C2102)
+	}
+	if size := binary.LittleEndian.Uint32(compressed[4:]); int(size) != len(compressed)-8 {
+		t.Fatalf("Legacy block size != expected: %d != %d", size, len(compressed)-8)
+	}
+
+	output, err := ioutil.ReadAll(NewLegacyReader(bytes.NewReader(compressed)))
+	failOnError(t, "Failed reading legacy stream", err)
+	if !bytes.Equal(input, output) {
+		t.Fatalf("Decompressed output != input (lengths: %v bytes & %v bytes)", len(output), len(input))
+	}
+}
+
+func TestLegacyMultipleBlocks(t *testing.T) {
+	sample, err := ioutil.ReadFile("sample.txt")
+	failOnError(t, "Failed reading sample", err)
+	input := bytes.Repeat(sample, 2*legacyBlockSize/len(sample)+10)
+
+	// Write in odd sized pieces to exercise block boundaries.
+	var buf bytes.Buffer
+	w := NewLegacyWriter(&buf)
+	for rest := input; len(rest) > 0; {
+		n := 1000003
+		if n > len(rest) {
+			n = len(rest)
+		}
+		_, err := w.Write(rest[:n])
+		failOnError(t, "Failed writing to legacy writer", err)
+		rest = rest[n:]
+	}
+	failOnError(t, "Failed closing legacy writer", w.Close())
+
+	// Read in odd sized pieces too.
+	r := NewLegacyReader(&buf)
+	var output bytes.Buffer
+	dst := make([]byte, 77777)
+	for {
+		n, err := r.Read(dst)
+		output.Write(dst[:n])
+		if err == io.EOF {
+			break
+		}
+		failOnError(t, "Failed reading legacy stream", err)
+	}
+	if !bytes.Equal(input, output.Bytes()) {
+		t.Fatalf("Decompressed output != input (lengths: %v bytes & %v bytes)", output.Len(), len(input))
+	}
+}
+
+func TestLegacyEmpty(t *testing.T) {
+	compressed := compressLegacy(t, nil)
+	if len(compressed) != 4 {
+		t.Fatalf("Empty legacy stream should only hold the magic number, got %d bytes", len(compressed))
+	}
+	output, err := ioutil.ReadAll(NewLegacyReader(bytes.NewReader(compressed)))
+	failOnError(t, "Failed reading legacy stream", err)
+	if len(output) != 0 {
+		t.Fatalf("Expected no output, got %d bytes", len(output))
+	}
+}
+
+func TestLegacyConcatenated(t *testing.T) {
+	compressed := append(compressLegacy(t, []byte("first ")), compressLegacy(t, []byte("second"))...)
+	output, err := ioutil.ReadAll(NewLegacyReader(bytes.NewReader(compressed)))
+	failOnError(t, "Failed reading legacy stream", err)
+	if string(output) != "first second" {
+		t.Fatalf("Decompressed output != expected: %q", output)
+	}
+}
+
+func TestLegacyErrors(t *testing.T) {
+	if _, err := ioutil.ReadAll(NewLegacyReader(bytes.NewReader(compressFrame(t, []byte("frame"))))); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Reading a frame as a legacy stream should have failed with ErrCorrupt, got %v", err)
+	}
+
+	compressed := compressLegacy(t, []byte("Hello world, this is quite something"))
+	_, err := ioutil.ReadAll(NewLegacyReader(bytes.NewReader(compressed[:len(compressed)-1])))
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Error should have been ErrTruncated, was %v instead", err)
+	}
+
+	_, err = ioutil.ReadAll(NewLegacyReader(bytes.NewReader([]byte{0x02, 0x21, 0x4c, 0x18, 0, 0, 0, 0})))
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Error should have been ErrCorrupt for an empty block, was %v instead", err)
+	}
+
+	w := NewLegacyWriter(ioutil.Discard)
+	failOnError(t, "Failed closing legacy writer", w.Close())
+	if _, err := w.Write([]byte("late")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Writing to a closed legacy writer should have failed with ErrClosed, got %v", err)
+	}
+}
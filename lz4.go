@@ -42,15 +42,257 @@ func clen(s []byte) C.int {
 }
 
 // Uncompress with a known output size. len(out) should be equal to
-// the length of the uncompressed out.
+// the length of the uncompressed out.  Returns ErrDstTooSmall if it is not
+// large enough, and ErrCorrupt if in is malformed or empty.
 func Uncompress(out, in []byte) (outSize int, err error) {
+	if len(in) == 0 {
+		// Even an empty input compresses to a byte, and lz4 reads it
+		// regardless.
+		return 0, ErrCorrupt
+	}
 	outSize = int(C.LZ4_decompress_safe(p(in), p(out), clen(in), clen(out)))
 	if outSize < 0 {
-		err = errors.New("Malformed compression stream")
+		err = uncompressError(in, 0, len(out))
 	}
 	return
 }
 
+// AppendCompress compresses src and appends the result to dst, growing dst
+// as needed, and returns the extended slice.
+func AppendCompress(dst, src []byte) ([]byte, error) {
+	bound := CompressBound(src)
+	dst = grow(dst, bound)
+	n, err := Compress(dst[len(dst):len(dst)+bound], src)
+	if err != nil {
+		return dst, err
+	}
+	return dst[:len(dst)+n], nil
+}
+
+// AppendUncompress decompresses src and appends the result to dst, growing
+// dst as needed, and returns the extended slice.  Decompression fails if the
+// result would be larger than maxSize bytes, which must not be negative.
+func AppendUncompress(dst, src []byte, maxSize int) ([]byte, error) {
+	if maxSize < 0 {
+		return dst, fmt.Errorf("lz4: negative maxSize %d", maxSize)
+	}
+	dst = grow(dst, maxSize)
+	n, err := Uncompress(dst[len(dst):len(dst)+maxSize], src)
+	if err != nil {
+		return dst, err
+	}
+	return dst[:len(dst)+n], nil
+}
+
+// UncompressAlloc decompresses in, whose uncompressed size is unknown, into
+// a newly allocated slice.  It starts with a buffer of a few times the size of
+// in, and retries with buffers twice as large until the data fits, up to
+// maxSize bytes.  It returns ErrOutputTooLarge if the data does not fit into
+// maxSize bytes, and an error if maxSize is negative.
+func UncompressAlloc(in []byte, maxSize int) ([]byte, error) {
+	if maxSize < 0 {
+		return nil, fmt.Errorf("lz4: negative maxSize %d", maxSize)
+	}
+	size := 4*len(in) + 64
+	for {
+		if size > maxSize {
+			size = maxSize
+		}
+		out := make([]byte, size)
+		n, err := Uncompress(out, in)
+		if err == nil {
+			return out[:n], nil
+		}
+		if err != ErrDstTooSmall {
+			return nil, err
+		}
+		if size == maxSize {
+			return nil, ErrOutputTooLarge
+		}
+		size *= 2
+	}
+}
+
+// blockSizeExceeds walks the sequences of the compressed block in, without
+// decompressing them, and reports whether it decompresses to more than
+// maxSize bytes.  It reports false if in is malformed before that point.
+// Matches may refer back into a dictionary of dictSize bytes.
+func blockSizeExceeds(in []byte, dictSize, maxSize int) bool {
+	pos, size := 0, 0
+	// readLength adds the extra bytes of a literal or match length to n.
+	readLength := func(n int) (int, bool) {
+		for {
+			if pos >= len(in) {
+				return 0, false
+			}
+			b := in[pos]
+			pos++
+			n += int(b)
+			if b != 255 {
+				return n, true
+			}
+		}
+	}
+
+	for pos < len(in) {
+		token := in[pos]
+		pos++
+		literals := int(token >> 4)
+		if literals == 15 {
+			var ok bool
+			if literals, ok = readLength(literals); !ok {
+				return false
+			}
+		}
+		pos += literals
+		size += literals
+		if pos > len(in) {
+			return false
+		}
+		if size > maxSize {
+			return true
+		}
+		if pos == len(in) {
+			// The last sequence has no match.
+			return false
+		}
+
+		if pos+2 > len(in) {
+			return false
+		}
+		offset := int(binary.LittleEndian.Uint16(in[pos:]))
+		pos += 2
+		if offset == 0 || offset > dictSize+size {
+			return false
+		}
+		match := int(token & 15)
+		if match == 15 {
+			var ok bool
+			if match, ok = readLength(match); !ok {
+				return false
+			}
+		}
+		size += match + 4
+		if size > maxSize {
+			return true
+		}
+	}
+	return false
+}
+
+// grow returns b with room for at least n more bytes.
+func grow(b []byte, n int) []byte {
+	if cap(b)-len(b) < n {
+		nb := make([]byte, len(b), len(b)+n)
+		copy(nb, b)
+		b = nb
+	}
+	return b
+}
+
+// UncompressPrefix is like Uncompress, but stops early, once the first
+// targetSize bytes of the block are decompressed, which is much faster than
+// decompressing the whole block when only its start is needed.  out must be
+// at least targetSize bytes long.  Returns the number of bytes written to
+// out, which is at least targetSize unless the block is shorter, and at most
+// len(out).
+func UncompressPrefix(out, in []byte, targetSize int) (outSize int, err error) {
+	if targetSize < 0 {
+		return 0, fmt.Errorf("lz4: negative targetSize %d", targetSize)
+	}
+	if targetSize > len(out) {
+		targetSize = len(out)
+	}
+
+	// lz4 decodes past targetSize, so find out how much room it needs first,
+	// which also rejects malformed blocks without decompressing them.
+	size, ok := prefixDecodeSize(in, targetSize)
+	if !ok || size > MaxInputSize {
+		return 0, ErrCorrupt
+	}
+	buf := out
+	if len(buf) < size {
+		buf = make([]byte, size)
+	}
+	outSize = int(C.LZ4_decompress_safe_partial(p(in), p(buf), clen(in), C.int(targetSize), clen(buf)))
+	if outSize < 0 {
+		return 0, ErrCorrupt
+	}
+	return copy(out, buf[:outSize]), nil
+}
+
+// prefixDecodeSize walks the sequences of the compressed block in like
+// LZ4_decompress_safe_partial does for targetSize, without decompressing
+// them, and returns the size of the buffer it needs.  lz4 decodes every
+// sequence whose literals end before targetSize, with its match, then the
+// literals of the next one, and wants 12 bytes of room past targetSize and 5
+// past every match.  It reports false if in is malformed before that point.
+func prefixDecodeSize(in []byte, targetSize int) (int, bool) {
+	pos, size := 0, 0
+	need := targetSize + 12
+	for {
+		if pos >= len(in) {
+			return 0, false
+		}
+		token := in[pos]
+		pos++
+		literals := int(token >> 4)
+		if literals == 15 {
+			// Like lz4, stop reading the length 15 bytes before the end.
+			for {
+				if pos >= len(in) {
+					return 0, false
+				}
+				b := in[pos]
+				pos++
+				literals += int(b)
+				if b != 255 || pos >= len(in)-15 {
+					break
+				}
+			}
+		}
+		if literals > len(in)-pos {
+			return 0, false
+		}
+		if size+literals > targetSize || pos+literals > len(in)-8 {
+			// The last sequence lz4 decodes.
+			if size+literals > need {
+				need = size + literals
+			}
+			return need, true
+		}
+		pos += literals
+		size += literals
+
+		offset := int(binary.LittleEndian.Uint16(in[pos:]))
+		pos += 2
+		if offset > size {
+			return 0, false
+		}
+		match := int(token & 15)
+		if match == 15 {
+			for {
+				if pos > len(in)-5 {
+					return 0, false
+				}
+				b := in[pos]
+				pos++
+				match += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		size += match + 4
+		if size > MaxInputSize {
+			return 0, false
+		}
+		if size+5 > need {
+			need = size + 5
+		}
+	}
+}
+
 // CompressBound calculates the size of the output buffer needed by
 // Compress. This is based on the following macro:
 //
@@ -74,79 +316,205 @@ func CompressBoundInt(inputSize int) int {
 // should have enough space for the compressed data (use CompressBound
 // to calculate). Returns the number of bytes in the out slice.
 func Compress(out, in []byte) (outSize int, err error) {
-	outSize = int(C.LZ4_compress_limitedOutput(p(in), p(out), clen(in), clen(out)))
+	return CompressFast(out, in, 1)
+}
+
+// CompressFast is like Compress, but trades compression ratio for speed.
+// Each increment of acceleration above 1 makes compression about 3% faster.
+// Values of 1 or less are the same as Compress.
+func CompressFast(out, in []byte, acceleration int) (outSize int, err error) {
+	outSize = int(C.LZ4_compress_fast(p(in), p(out), clen(in), clen(out), C.int(acceleration)))
 	if outSize == 0 {
-		err = errors.New("Insufficient space for compression")
+		err = compressError(in)
 	}
 	return
 }
 
+// CompressDestSize compresses as much of in as fits into out, and returns
+// how many bytes of in were consumed and how many bytes were written to out.
+// If all of in fits, it behaves like Compress.  The output is a regular block
+// that Uncompress decompresses to in[:consumed].
+func CompressDestSize(out, in []byte) (consumed, written int, err error) {
+	inSize := clen(in)
+	written = int(C.LZ4_compress_destSize(p(in), p(out), &inSize, clen(out)))
+	if written == 0 {
+		return 0, 0, compressError(in)
+	}
+	return int(inSize), written, nil
+}
+
+// blockStream is the lz4 stream a Writer compresses its blocks with.  Blocks
+// may refer back to the previous block, which Writer keeps in memory.
+type blockStream interface {
+	// compress compresses in into out and returns the number of bytes
+	// written to out, or 0 on failure.
+	compress(out, in []byte) int
+	// saveDict copies the end of the last block into dict, and makes the
+	// next block refer back to dict only.
+	saveDict(dict []byte)
+	// reset starts a new stream, which does not refer to earlier blocks.
+	reset()
+}
+
+// fastStream compresses blocks with LZ4_compress_fast_continue.  The lz4
+// stream is kept in Go memory, so that it lives as long as the Writer and
+// survives Close for Reset.
+type fastStream struct {
+	lz4Stream    C.LZ4_stream_t
+	acceleration int
+	// dict primes lz4Stream at every reset, if set.
+	dict *Dictionary
+}
+
+func (s *fastStream) compress(out, in []byte) int {
+	return int(C.LZ4_compress_fast_continue(&s.lz4Stream, p(in), p(out), clen(in), clen(out), C.int(s.acceleration)))
+}
+
+func (s *fastStream) saveDict(dict []byte) {
+	C.LZ4_saveDict(&s.lz4Stream, p(dict), clen(dict))
+}
+
+func (s *fastStream) reset() {
+	if s.dict != nil {
+		// The dictionary was loaded into its own stream once, copying it is
+		// much cheaper than loading it again.
+		s.lz4Stream = s.dict.stream
+	} else {
+		C.LZ4_resetStream(&s.lz4Stream)
+	}
+}
+
 // Writer is an io.WriteCloser that lz4 compress its input.
 type Writer struct {
-	compressionBuffer      [2][streamingBlockSize]byte
-	lz4Stream              *C.LZ4_stream_t
+	compressionBuffer      [streamingBlockSize]byte
+	dictBuffer             [streamingBlockSize]byte
+	compressedBuf          [boudedStreamingBlockSize]byte
+	header                 [4]byte
+	stream                 blockStream
+	closed                 bool
 	underlyingWriter       io.Writer
-	inpBufIndex            int
+	inpBufLen              int
 	totalCompressedWritten int
 }
 
 // NewWriter creates a new Writer. Writes to
 // the writer will be written in compressed form to w.
 func NewWriter(w io.Writer) *Writer {
+	return NewWriterFast(w, 1)
+}
+
+// NewWriterFast is like NewWriter, but compresses with the given
+// acceleration, as CompressFast does.
+func NewWriterFast(w io.Writer, acceleration int) *Writer {
+	stream := &fastStream{acceleration: acceleration}
+	stream.reset()
 	return &Writer{
-		lz4Stream:        C.LZ4_createStream(),
+		stream:           stream,
 		underlyingWriter: w,
 	}
 }
 
-// Write writes a compressed form of src to the underlying io.Writer.
+// Reset discards the Writer's state and makes it write a new stream to w,
+// with the same settings and dictionary as before.  It reuses all the buffers
+// of the Writer, so that Writers can be kept in a sync.Pool instead of being
+// allocated for every stream.  Reset may be called after Close.
+func (w *Writer) Reset(writer io.Writer) {
+	w.stream.reset()
+	w.underlyingWriter = writer
+	w.inpBufLen = 0
+	w.totalCompressedWritten = 0
+	w.closed = false
+}
+
+// Write buffers src and writes a compressed block to the underlying io.Writer
+// every time streamingBlockSize bytes have been buffered.  src may be of any
+// size.  Flush and Close write out whatever remains buffered.
 func (w *Writer) Write(src []byte) (int, error) {
-	if len(src) > streamingBlockSize+4 {
-		return 0, fmt.Errorf("block is too large: %d > %d", len(src), streamingBlockSize+4)
+	if w.closed {
+		return 0, ErrClosed
 	}
 
-	inpPtr := w.compressionBuffer[w.inpBufIndex]
+	written := 0
+	for len(src) > 0 {
+		n := copy(w.compressionBuffer[w.inpBufLen:], src)
+		w.inpBufLen += n
+		written += n
+		src = src[n:]
+
+		if w.inpBufLen == streamingBlockSize {
+			if err := w.writeBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
 
-	var compressedBuf [boudedStreamingBlockSize]byte
-	copy(inpPtr[:], src)
+// writeBlock compresses the buffered input and writes it to the underlying
+// io.Writer as a block.
+func (w *Writer) writeBlock() error {
+	if w.inpBufLen == 0 {
+		return nil
+	}
 
-	written := int(C.LZ4_compress_fast_continue(
-		w.lz4Stream,
-		(*C.char)(unsafe.Pointer(&inpPtr[0])),
-		(*C.char)(unsafe.Pointer(&compressedBuf[0])),
-		C.int(len(src)),
-		C.int(len(compressedBuf)),
-		1))
+	inpPtr := w.compressionBuffer[:w.inpBufLen]
+	written := w.stream.compress(w.compressedBuf[:], inpPtr)
 	if written <= 0 {
-		return 0, errors.New("error compressing")
+		return compressError(inpPtr)
 	}
+	// The reader only keeps the previous block, so the next block may only
+	// refer back to this one.  Flush makes blocks shorter than the window,
+	// so the stream would otherwise see older blocks too.
+	w.stream.saveDict(w.dictBuffer[:w.inpBufLen])
 
 	// Write "header" to the buffer for decompression
-	var header [4]byte
-	binary.LittleEndian.PutUint32(header[:], uint32(written))
-	_, err := w.underlyingWriter.Write(header[:])
+	binary.LittleEndian.PutUint32(w.header[:], uint32(written))
+	_, err := w.underlyingWriter.Write(w.header[:])
 	if err != nil {
-		return 0, err
+		return err
 	}
 
 	// Write to underlying buffer
-	_, err = w.underlyingWriter.Write(compressedBuf[:written])
+	_, err = w.underlyingWriter.Write(w.compressedBuf[:written])
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	w.inpBufIndex = (w.inpBufIndex + 1) % 2
+	w.inpBufLen = 0
 	w.totalCompressedWritten += written + 4
-	return len(src), nil
+	return nil
+}
+
+// Flush compresses any buffered data into a block and writes it to the
+// underlying io.Writer, even if the block is not full.
+func (w *Writer) Flush() error {
+	if w.closed {
+		return ErrClosed
+	}
+	return w.writeBlock()
 }
 
-// Close releases all the resources occupied by Writer.
-// w cannot be used after the release.
+// Close writes any buffered data followed by an end of stream marker.  w
+// cannot be written to after Close, which fails with ErrClosed, until it is
+// Reset.  It does not close the underlying io.Writer.
 func (w *Writer) Close() error {
-	if w.lz4Stream != nil {
-		C.LZ4_freeStream(w.lz4Stream)
-		w.lz4Stream = nil
+	if w.closed {
+		return nil
 	}
+	w.closed = true
+
+	if err := w.writeBlock(); err != nil {
+		return err
+	}
+
+	// A block can never compress to 0 bytes, so a 0 length header marks the
+	// end of the stream.
+	endMark := w.header[:]
+	binary.LittleEndian.PutUint32(endMark, 0)
+	if _, err := w.underlyingWriter.Write(endMark); err != nil {
+		return err
+	}
+	w.totalCompressedWritten += len(endMark)
 	return nil
 }
 
@@ -157,6 +525,21 @@ type reader struct {
 	right            unsafe.Pointer
 	underlyingReader io.Reader
 	isLeft           bool
+	compressedBuf    [boudedStreamingBlockSize]byte
+	header           [4]byte
+	// decompressed is the last decompressed block, in left or right, and
+	// pos is how much of it has been returned by Read so far.
+	decompressed []byte
+	pos          int
+	// done is set once the end of stream marker has been read.
+	done bool
+	// dict keeps the dictionary the stream was started with, if any, in
+	// memory.
+	dict *Dictionary
+	// block is the index of the next block, and offset where it starts in
+	// the compressed stream.
+	block  int
+	offset int64
 }
 
 // NewReader creates a new io.ReadCloser.  Reads from the returned ReadCloser
@@ -164,19 +547,46 @@ type reader struct {
 // Close on the ReadCloser when done.  If this is not done, underlying objects
 // in the lz4 library will not be freed.
 func NewReader(r io.Reader) io.ReadCloser {
-	return &reader{
-		lz4Stream:        C.LZ4_createStreamDecode(),
-		underlyingReader: r,
-		isLeft:           true,
+	rdr := &reader{}
+	rdr.Reset(r)
+	return rdr
+}
+
+// Resetter is implemented by the io.ReadCloser returned by NewReader and
+// NewReaderDict.  Reset discards the reader's state and makes it read a new
+// stream from r, with the same dictionary as before.  It reuses all the
+// buffers of the reader, so that readers can be kept in a sync.Pool instead
+// of being allocated for every stream.  A reader that is Reset after Close
+// has to allocate its buffers again.
+type Resetter interface {
+	Reset(r io.Reader)
+}
+
+// Reset implements Resetter.
+func (r *reader) Reset(rdr io.Reader) {
+	if r.lz4Stream == nil {
+		r.lz4Stream = C.LZ4_createStreamDecode()
 		// double buffer needs to use C.malloc to make sure the same memory address
 		// allocate buffers in go memory will fail randomly since GC may move the memory
-		left:  C.malloc(boudedStreamingBlockSize),
-		right: C.malloc(boudedStreamingBlockSize),
+		r.left = C.malloc(boudedStreamingBlockSize)
+		r.right = C.malloc(boudedStreamingBlockSize)
+	}
+	if r.dict != nil {
+		C.LZ4_setStreamDecode(r.lz4Stream, p(r.dict.data), clen(r.dict.data))
+	} else {
+		C.LZ4_setStreamDecode(r.lz4Stream, nil, 0)
 	}
+	r.underlyingReader = rdr
+	r.isLeft = true
+	r.decompressed = nil
+	r.pos = 0
+	r.done = false
+	r.block = 0
+	r.offset = 0
 }
 
 // Close releases all the resources occupied by r.
-// r cannot be used after the release.
+// r cannot be used after the release: Read fails with ErrClosed.
 func (r *reader) Close() error {
 	if r.lz4Stream != nil {
 		C.LZ4_freeStreamDecode(r.lz4Stream)
@@ -185,21 +595,66 @@ func (r *reader) Close() error {
 
 	C.free(r.left)
 	C.free(r.right)
+	r.left, r.right = nil, nil
+	r.decompressed = nil
 	return nil
 }
 
-// Read decompresses `compressionBuffer` into `dst`.
+// Read decompresses data from the underlying io.Reader into dst.  Blocks
+// that do not fit into dst are returned over several calls.  Read returns
+// io.EOF after the end of stream marker written by Writer.Close.  Other
+// errors are returned as a *StreamError, which wraps ErrTruncated if the
+// underlying reader ends before the marker, and ErrCorrupt if the stream is
+// malformed.  Streams written by versions of this package without the
+// marker end with ErrTruncated, after all their data.
 func (r *reader) Read(dst []byte) (int, error) {
+	if r.lz4Stream == nil {
+		return 0, ErrClosed
+	}
+	if len(dst) == 0 {
+		return 0, nil
+	}
+
+	// Loop rather than return 0 bytes for empty blocks.
+	for r.pos == len(r.decompressed) {
+		if err := r.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+
+	copied := copy(dst, r.decompressed[r.pos:])
+	r.pos += copied
+	return copied, nil
+}
+
+// readBlock reads the next block from the underlying io.Reader and
+// decompresses it into whichever of left and right was not used last, so that
+// the previous block stays available to the decoder.
+func (r *reader) readBlock() error {
+	if r.done {
+		return io.EOF
+	}
 	blockSize, err := r.readSize(r.underlyingReader)
 	if err != nil {
-		return 0, err
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrTruncated
+		}
+		return r.blockError(err)
+	}
+	if blockSize == 0 {
+		r.done = true
+		return io.EOF
+	}
+	if blockSize > len(r.compressedBuf) {
+		return r.blockError(fmt.Errorf("%w: block is too large: %d > %d", ErrCorrupt, blockSize, len(r.compressedBuf)))
 	}
 
-	// read blockSize from r.underlyingReader --> readBuffer
-	var uncompressedBuf [boudedStreamingBlockSize]byte
-	_, err = io.ReadFull(r.underlyingReader, uncompressedBuf[:blockSize])
+	_, err = io.ReadFull(r.underlyingReader, r.compressedBuf[:blockSize])
 	if err != nil {
-		return 0, err
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrTruncated
+		}
+		return r.blockError(err)
 	}
 
 	var ptr unsafe.Pointer
@@ -213,28 +668,34 @@ func (r *reader) Read(dst []byte) (int, error) {
 
 	written := int(C.LZ4_decompress_safe_continue(
 		r.lz4Stream,
-		(*C.char)(unsafe.Pointer(&uncompressedBuf[0])),
+		p(r.compressedBuf[:blockSize]),
 		(*C.char)(ptr),
 		C.int(blockSize),
 		C.int(streamingBlockSize),
 	))
-
 	if written < 0 {
-		return written, errors.New("error decompressing")
+		return r.blockError(ErrCorrupt)
 	}
-	// fmt.Println(hex.EncodeToString(ptr[:]))
-	mySlice := C.GoBytes(ptr, C.int(written))
-	copied := copy(dst[:written], mySlice)
-	return copied, nil
+
+	r.decompressed = (*[boudedStreamingBlockSize]byte)(ptr)[:written:written]
+	r.pos = 0
+	r.block++
+	r.offset += int64(4 + blockSize)
+	return nil
+}
+
+// blockError wraps err, which happened while reading the next block, in a
+// *StreamError.
+func (r *reader) blockError(err error) error {
+	return &StreamError{Block: r.block, Offset: r.offset, Err: err}
 }
 
 // read the 4-byte little endian size from the head of each stream compressed block
 func (r *reader) readSize(rdr io.Reader) (int, error) {
-	var temp [4]byte
-	_, err := io.ReadFull(rdr, temp[:])
+	_, err := io.ReadFull(rdr, r.header[:])
 	if err != nil {
 		return 0, err
 	}
 
-	return int(binary.LittleEndian.Uint32(temp[:])), nil
+	return int(binary.LittleEndian.Uint32(r.header[:])), nil
 }
@@ -3,11 +3,19 @@ package lz4
 // #cgo CFLAGS: -O3
 // #include "src/lz4hc.h"
 // #include "src/lz4hc.c"
+//
+// /* LZ4_attach_HC_dictionary is needed by lz4frame.c but missing from the
+//  * vendored lz4hc.c; it lives here because it needs the HC state layout. */
+// void LZ4_attach_HC_dictionary(LZ4_streamHC_t* working, const LZ4_streamHC_t* dict) {
+//     U32 level;
+//     if (dict == NULL) return;
+//     level = ((LZ4HC_Data_Structure*)working)->compressionLevel;
+//     memcpy(working, dict, sizeof(LZ4HC_Data_Structure));
+//     ((LZ4HC_Data_Structure*)working)->compressionLevel = level;
+// }
 import "C"
 
-import (
-	"fmt"
-)
+import "io"
 
 // CompressHC compresses in and puts the content in out. len(out)
 // should have enough space for the compressed data (use CompressBound
@@ -32,7 +40,46 @@ func CompressHCLevel(out, in []byte, level int) (outSize int, err error) {
 
 	outSize = int(C.LZ4_compressHC2_limitedOutput(p(in), p(out), clen(in), clen(out), C.int(level)))
 	if outSize == 0 {
-		err = fmt.Errorf("insufficient space for compression")
+		err = compressError(in)
 	}
 	return
 }
+
+// hcStream compresses blocks with LZ4_compress_HC_continue, and keeps the
+// previous block with LZ4_saveDictHC.  Like fastStream, it keeps the lz4
+// stream in Go memory.
+type hcStream struct {
+	lz4Stream C.LZ4_streamHC_t
+	level     int
+}
+
+func (s *hcStream) compress(out, in []byte) int {
+	return int(C.LZ4_compress_HC_continue(&s.lz4Stream, p(in), p(out), clen(in), clen(out)))
+}
+
+func (s *hcStream) saveDict(dict []byte) {
+	C.LZ4_saveDictHC(&s.lz4Stream, p(dict), clen(dict))
+}
+
+func (s *hcStream) reset() {
+	C.LZ4_resetStreamHC(&s.lz4Stream, C.int(s.level))
+}
+
+// NewWriterHC creates a new Writer that uses high-compression ratio
+// compression, with the compression level chosen automatically.  Its output
+// is read with NewReader, like that of NewWriter.
+func NewWriterHC(w io.Writer) *Writer {
+	return NewWriterLevel(w, 0)
+}
+
+// NewWriterLevel is like NewWriterHC but compresses at the given level.  To
+// automatically choose the compression level, use 0.  Otherwise, use any
+// value in the inclusive range 1 (worst) through 16 (best).
+func NewWriterLevel(w io.Writer, level int) *Writer {
+	stream := &hcStream{level: level}
+	stream.reset()
+	return &Writer{
+		stream:           stream,
+		underlyingWriter: w,
+	}
+}
@@ -6,7 +6,9 @@
 package lz4
 
 import (
+	"bytes"
 	"io/ioutil"
+	"math/rand"
 	"strings"
 	"testing"
 	"testing/quick"
@@ -216,3 +218,84 @@ func TestFuzzHC(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func streamCompress(t *testing.T, w *Writer, input []byte) {
+	// Write in odd sized pieces so that blocks do not line up with writes.
+	for rest := input; len(rest) > 0; {
+		n := 10007
+		if n > len(rest) {
+			n = len(rest)
+		}
+		_, err := w.Write(rest[:n])
+		failOnError(t, "Failed writing to compress object", err)
+		rest = rest[n:]
+	}
+	failOnError(t, "Failed closing writer", w.Close())
+}
+
+func TestStreamHC(t *testing.T) {
+	sample, err := ioutil.ReadFile("sample.txt")
+	failOnError(t, "Failed reading sample", err)
+	input := bytes.Repeat(sample, 100)
+
+	var fast bytes.Buffer
+	streamCompress(t, NewWriter(&fast), input)
+
+	for _, level := range []int{0, 1, 9, 16} {
+		var compressed bytes.Buffer
+		streamCompress(t, NewWriterLevel(&compressed, level), input)
+		if compressed.Len() > fast.Len() {
+			t.Errorf("HC level %d stream larger than fast stream: %d > %d", level, compressed.Len(), fast.Len())
+		}
+
+		r := NewReader(&compressed)
+		output, err := ioutil.ReadAll(r)
+		failOnError(t, "Failed to decompress", err)
+		r.Close()
+		if !bytes.Equal(input, output) {
+			t.Fatalf("HC level %d: decompressed output != input (lengths: %v bytes & %v bytes)", level, len(output), len(input))
+		}
+	}
+}
+
+func TestStreamHCFuzz(t *testing.T) {
+	f := func(input []byte, repeat uint8) bool {
+		input = bytes.Repeat(input, int(repeat))
+		var compressed bytes.Buffer
+		streamCompress(t, NewWriterHC(&compressed), input)
+
+		r := NewReader(&compressed)
+		defer r.Close()
+		output, err := ioutil.ReadAll(r)
+		failOnError(t, "Failed to decompress", err)
+		return bytes.Equal(input, output)
+	}
+
+	conf := &quick.Config{MaxCount: 200}
+	if testing.Short() {
+		conf.MaxCount = 20
+	}
+	if err := quick.Check(f, conf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamHCFlushAfterFullBlock(t *testing.T) {
+	for _, level := range []int{0, 9} {
+		var compressed bytes.Buffer
+		streamFlushAfterFullBlock(t, NewWriterLevel(&compressed, level), &compressed)
+	}
+}
+
+func TestStreamHCPreviousBlock(t *testing.T) {
+	// A block that repeats most of the previous one compresses to almost
+	// nothing.
+	input := make([]byte, streamingBlockSize)
+	rand.New(rand.NewSource(1)).Read(input)
+	input = append(input, input[100:]...)
+	var compressed bytes.Buffer
+	streamCompress(t, NewWriterHC(&compressed), input)
+	if compressed.Len() > streamingBlockSize+streamingBlockSize/10 {
+		t.Fatalf("Second block did not refer back to the first: %d bytes", compressed.Len())
+	}
+}
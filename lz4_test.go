@@ -6,11 +6,16 @@
 package lz4
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"runtime"
 	"runtime/debug"
 	"strconv"
 	"strings"
@@ -110,6 +115,294 @@ func TestNoCompression(t *testing.T) {
 	}
 }
 
+func TestCompressFast(t *testing.T) {
+	input, err := ioutil.ReadFile("sample.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := 0
+	for _, acceleration := range []int{-1, 0, 1, 2, 8, 64} {
+		output := make([]byte, CompressBound(input))
+		outSize, err := CompressFast(output, input, acceleration)
+		if err != nil {
+			t.Fatalf("Compression failed: %v", err)
+		}
+		if acceleration <= 1 && outSize != corpusSize {
+			t.Errorf("Acceleration %d output length != expected: %d != %d", acceleration, outSize, corpusSize)
+		}
+		if outSize < previous {
+			t.Errorf("Acceleration %d compressed better than a lower one: %d < %d", acceleration, outSize, previous)
+		}
+		previous = outSize
+
+		decompressed := make([]byte, len(input))
+		_, err = Uncompress(decompressed, output[:outSize])
+		if err != nil {
+			t.Fatalf("Decompression failed: %v", err)
+		}
+		if !bytes.Equal(decompressed, input) {
+			t.Fatalf("Acceleration %d: decompressed output != input", acceleration)
+		}
+	}
+
+	if _, err := CompressFast(make([]byte, 1), input, 8); err == nil {
+		t.Fatalf("Compression should have failed but didn't")
+	}
+}
+
+func TestStreamFast(t *testing.T) {
+	input := []byte(strings.Repeat("Hello world, this is quite something. 0123456789 ", 10000))
+
+	var compressed bytes.Buffer
+	w := NewWriterFast(&compressed, 32)
+	_, err := w.Write(input)
+	failOnError(t, "Failed writing to compress object", err)
+	failOnError(t, "Failed closing writer", w.Close())
+
+	r := NewReader(&compressed)
+	defer r.Close()
+	output, err := ioutil.ReadAll(r)
+	failOnError(t, "Failed to decompress", err)
+	if !bytes.Equal(input, output) {
+		t.Fatalf("Decompressed output != input (lengths: %v bytes & %v bytes)", len(output), len(input))
+	}
+}
+
+func TestCompressDestSize(t *testing.T) {
+	input, err := ioutil.ReadFile("sample.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{16, 100, 1000, 4096, CompressBound(input)} {
+		output := make([]byte, size)
+		consumed, written, err := CompressDestSize(output, input)
+		if err != nil {
+			t.Fatalf("Compression into %d bytes failed: %v", size, err)
+		}
+		if written > size || consumed == 0 || consumed > len(input) {
+			t.Fatalf("Compression into %d bytes consumed %d and wrote %d bytes", size, consumed, written)
+		}
+		if size < corpusSize && written < size-16 {
+			t.Errorf("Compression into %d bytes should have filled it, wrote %d bytes", size, written)
+		}
+		if size >= corpusSize && (consumed != len(input) || written != corpusSize) {
+			t.Errorf("Compression into %d bytes should have been like Compress: consumed %d and wrote %d bytes", size, consumed, written)
+		}
+
+		decompressed := make([]byte, consumed)
+		_, err = Uncompress(decompressed, output[:written])
+		if err != nil {
+			t.Fatalf("Decompression failed: %v", err)
+		}
+		if !bytes.Equal(decompressed, input[:consumed]) {
+			t.Fatalf("Decompressed output != input[:%d]", consumed)
+		}
+	}
+
+	if _, _, err := CompressDestSize(nil, input); err == nil {
+		t.Fatalf("Compression should have failed but didn't")
+	}
+}
+
+func TestUncompressPrefix(t *testing.T) {
+	sample, err := ioutil.ReadFile("sample.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A long run of spaces makes for a long match.
+	input := append(append(sample, bytes.Repeat([]byte(" "), 100000)...), "the end"...)
+	compressed := make([]byte, CompressBound(input))
+	n, err := Compress(compressed, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed = compressed[:n]
+
+	for _, targetSize := range []int{0, 1, 15, 100, 1000, len(sample), len(sample) + 50000, len(input)} {
+		for _, outSize := range []int{targetSize, targetSize + 100, len(input)} {
+			output := make([]byte, outSize)
+			n, err := UncompressPrefix(output, compressed, targetSize)
+			if err != nil {
+				t.Fatalf("Decompressing %d bytes into %d failed: %v", targetSize, outSize, err)
+			}
+			if n < targetSize || n > outSize {
+				t.Fatalf("Decompressing %d bytes into %d returned %d bytes", targetSize, outSize, n)
+			}
+			if !bytes.Equal(output[:n], input[:n]) {
+				t.Fatalf("Decompressing %d bytes into %d: output != input", targetSize, outSize)
+			}
+		}
+	}
+
+	// Blocks shorter than targetSize are decompressed whole.
+	output := make([]byte, len(input)+100)
+	n, err = UncompressPrefix(output, compressed, len(output))
+	if err != nil || n != len(input) {
+		t.Fatalf("Decompressing a whole block returned %d bytes and error %v", n, err)
+	}
+
+	if _, err := UncompressPrefix(make([]byte, 10), compressed[:5], 10); err == nil {
+		t.Fatalf("Decompression should have failed but didn't")
+	}
+	if _, err := UncompressPrefix(make([]byte, 10), nil, 10); err != ErrCorrupt {
+		t.Fatalf("Decompression of empty input should have failed with ErrCorrupt, got %v", err)
+	}
+	if _, err := UncompressPrefix(make([]byte, 4), nil, -20); err == nil {
+		t.Fatalf("Decompression with a negative targetSize should have failed but didn't")
+	}
+}
+
+func TestUncompressPrefixCorrupt(t *testing.T) {
+	// Random input is rejected without growing the buffer again and again.
+	garbage := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(garbage)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := UncompressPrefix(make([]byte, 100), garbage, 100); err != ErrCorrupt {
+		t.Fatalf("Decompression of random input should have failed with ErrCorrupt, got %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 4<<20 {
+		t.Fatalf("Decompression of random input allocated %d bytes", allocated)
+	}
+}
+
+func TestUncompressPrefixFuzz(t *testing.T) {
+	f := func(input []byte, repeat uint8, targetSize uint16) bool {
+		input = bytes.Repeat(input, int(repeat)+1)
+		compressed, err := AppendCompress(nil, input)
+		failOnError(t, "Compression failed", err)
+		output := make([]byte, int(targetSize)+len(input))
+		n, err := UncompressPrefix(output, compressed, int(targetSize))
+		failOnError(t, "Decompression failed", err)
+		return bytes.Equal(output[:n], input[:n]) && (n >= int(targetSize) || n == len(input))
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppendCompress(t *testing.T) {
+	input, err := ioutil.ReadFile("sample.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := []byte("prefix")
+	for _, dst := range [][]byte{nil, prefix, append(make([]byte, 0, 100000), prefix...)} {
+		compressed, err := AppendCompress(dst, input)
+		if err != nil {
+			t.Fatalf("Compression failed: %v", err)
+		}
+		if !bytes.HasPrefix(compressed, dst) || len(compressed) != len(dst)+corpusSize {
+			t.Fatalf("AppendCompress returned %d bytes, expected %q followed by %d bytes", len(compressed), dst, corpusSize)
+		}
+
+		// dst may share memory with compressed, so decompress after a copy.
+		output, err := AppendUncompress(append([]byte(nil), dst...), compressed[len(dst):], len(input))
+		if err != nil {
+			t.Fatalf("Decompression failed: %v", err)
+		}
+		if !bytes.Equal(output, append(append([]byte(nil), dst...), input...)) {
+			t.Fatalf("AppendUncompress output != dst followed by input")
+		}
+	}
+
+	// The slice is only reallocated when it is too small.
+	buf := make([]byte, 0, 100000)
+	compressed, err := AppendCompress(buf, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &compressed[0] != &buf[:1][0] {
+		t.Fatalf("AppendCompress should have used the capacity of dst")
+	}
+
+	if _, err := AppendUncompress(nil, compressed, len(input)-1); err == nil {
+		t.Fatalf("Decompression larger than maxSize should have failed but didn't")
+	}
+	if _, err := AppendUncompress(nil, compressed, -1); err == nil {
+		t.Fatalf("Decompression with a negative maxSize should have failed but didn't")
+	}
+	empty, err := AppendCompress(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := AppendUncompress(prefix, empty, 0)
+	if err != nil || !bytes.Equal(output, prefix) {
+		t.Fatalf("Decompression of empty input returned %q and error %v", output, err)
+	}
+}
+
+func TestUncompressAlloc(t *testing.T) {
+	sample, err := ioutil.ReadFile("sample.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range [][]byte{nil, []byte("a"), sample, make([]byte, 1<<20)} {
+		compressed, err := AppendCompress(nil, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := UncompressAlloc(compressed, len(input))
+		if err != nil {
+			t.Fatalf("Decompression of %d bytes failed: %v", len(input), err)
+		}
+		if !bytes.Equal(output, input) {
+			t.Fatalf("Decompressed output != input (lengths: %v bytes & %v bytes)", len(output), len(input))
+		}
+
+		if len(input) > 0 {
+			if _, err := UncompressAlloc(compressed, len(input)-1); err != ErrOutputTooLarge {
+				t.Fatalf("Decompression of %d bytes into %d should have failed with ErrOutputTooLarge, got %v", len(input), len(input)-1, err)
+			}
+		}
+	}
+
+	compressed, err := AppendCompress(nil, sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, corrupt := range [][]byte{compressed[:len(compressed)/2], {0xf0, 1, 2, 3}} {
+		_, err := UncompressAlloc(corrupt, 1<<20)
+		if err == nil || err == ErrOutputTooLarge {
+			t.Fatalf("Decompression of corrupt data should have failed, got %v", err)
+		}
+	}
+	for _, empty := range [][]byte{nil, {}} {
+		if _, err := UncompressAlloc(empty, 1000); err != ErrCorrupt {
+			t.Fatalf("Decompression of empty input should have failed with ErrCorrupt, got %v", err)
+		}
+		if _, err := Uncompress(make([]byte, 10), empty); err != ErrCorrupt {
+			t.Fatalf("Decompression of empty input should have failed with ErrCorrupt, got %v", err)
+		}
+		if _, err := UncompressDict(make([]byte, 10), empty, jsonDictionary()); err != ErrCorrupt {
+			t.Fatalf("Decompression of empty input should have failed with ErrCorrupt, got %v", err)
+		}
+	}
+	if _, err := UncompressAlloc(compressed, -1); err == nil {
+		t.Fatal("Decompression with a negative maxSize should have failed")
+	}
+}
+
+func TestBlockSizeExceedsFuzz(t *testing.T) {
+	f := func(input []byte) bool {
+		compressed, err := AppendCompress(nil, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return !blockSizeExceeds(compressed, 0, len(input)) &&
+			(len(input) == 0 || blockSizeExceeds(compressed, 0, len(input)-1))
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestCompressionError(t *testing.T) {
 	input := []byte(strings.Repeat("Hello world, this is quite something", 10))
 	output := make([]byte, 1)
@@ -214,8 +507,10 @@ func TestSimpleCompressDecompress(t *testing.T) {
 	data := []byte("this\nis\njust\na\ntestttttttttt.")
 	w := bytes.NewBuffer(nil)
 	wc := NewWriter(w)
-	defer wc.Close()
 	_, err := wc.Write(data)
+	failOnError(t, "Failed writing to compress object", err)
+	// Data is buffered until a block is full or the writer is closed.
+	failOnError(t, "Failed to close compress object", wc.Close())
 
 	// Decompress
 	bufOut := bytes.NewBuffer(nil)
@@ -236,9 +531,12 @@ func TestIOCopyStreamSimpleCompressionDecompression(t *testing.T) {
 }
 
 func testIOCopy(t *testing.T, src io.Reader, filename string) {
-	fname := filename + "testcom" + ".lz4"
+	// Not shakespeare.txttestcom.lz4, which is a stream written before there
+	// was an end marker, for the decompression tests.
+	fname := filename + "testcom.new" + ".lz4"
 	file, err := os.Create(fname)
 	failOnError(t, "Failed creating to file", err)
+	defer os.Remove(fname)
 
 	writer := NewWriter(file)
 
@@ -298,6 +596,15 @@ func checkfilecontentIsSame(t *testing.T, f1, f2 string) bool {
 	return bytes.Equal(bytes1, bytes2)
 }
 
+// expectNoEndMarker checks that reading shakespeare.txttestcom.lz4, which was
+// written before streams had an end marker, failed with ErrTruncated.  The
+// callers then check that all the data was read anyway.
+func expectNoEndMarker(t *testing.T, err error) {
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Stream without an end marker should end with ErrTruncated, got %v", err)
+	}
+}
+
 type testfilenames struct {
 	name     string
 	filename string
@@ -336,8 +643,7 @@ func IOCopyDecompressionwithName(t *testing.T, fileoutcomename string, originalf
 	// Decompress with streaming API
 	r := NewReader(fi)
 	_, err = io.Copy(fileNew, r)
-
-	failOnError(t, "Failed writing to file", err)
+	expectNoEndMarker(t, err)
 
 	if !checkfilecontentIsSame(t, originalfileName, fileoutcomename) {
 		info1, _ := os.Stat(originalfileName)
@@ -369,7 +675,7 @@ func TestIOCopyDecompression(t *testing.T) {
 	// Decompress with streaming API
 	r := NewReader(fi)
 	_, err = io.Copy(fileNew, r)
-	failOnError(t, "Failed writing to file", err)
+	expectNoEndMarker(t, err)
 	checkFilename := "shakespeare.txt"
 	if !checkfilecontentIsSame(t, checkFilename, fnameNew) {
 		t.Fatalf("Original VS Compressed file contents not same: %s != %s", checkFilename, fnameNew)
@@ -412,6 +718,252 @@ func TestContinueCompress(t *testing.T) {
 
 }
 
+func TestStreamLargeWrite(t *testing.T) {
+	input := make([]byte, 5*streamingBlockSize+123)
+	for i := range input {
+		input[i] = byte(i % 251)
+	}
+
+	var compressed bytes.Buffer
+	w := NewWriter(&compressed)
+	n, err := w.Write(input)
+	failOnError(t, "Failed writing to compress object", err)
+	if n != len(input) {
+		t.Fatalf("Did not write everything: %v != %v", n, len(input))
+	}
+	failOnError(t, "Failed closing writer", w.Close())
+
+	r := NewReader(&compressed)
+	defer r.Close()
+	output, err := ioutil.ReadAll(r)
+	failOnError(t, "Failed to decompress", err)
+	if !bytes.Equal(input, output) {
+		t.Fatalf("Decompressed output != input (lengths: %v bytes & %v bytes)", len(output), len(input))
+	}
+}
+
+func TestStreamSmallWritesShareBlocks(t *testing.T) {
+	payload := []byte("Hello World!")
+
+	var compressed bytes.Buffer
+	w := NewWriter(&compressed)
+	for i := 0; i < 1000; i++ {
+		_, err := w.Write(payload)
+		failOnError(t, "Failed writing to compress object", err)
+	}
+	if compressed.Len() != 0 {
+		t.Fatalf("Writer should buffer less than a block, wrote %d bytes", compressed.Len())
+	}
+	failOnError(t, "Failed closing writer", w.Close())
+
+	// A single block: its length header and its content, then the end marker.
+	if size := int(binary.LittleEndian.Uint32(compressed.Bytes())); size+8 != compressed.Len() {
+		t.Fatalf("Expected a single block of %d bytes, stream is %d bytes", size, compressed.Len())
+	}
+}
+
+func TestStreamSmallReads(t *testing.T) {
+	input := []byte(strings.Repeat("Hello world, this is quite something\n", 5000))
+	var compressed bytes.Buffer
+	w := NewWriter(&compressed)
+	_, err := w.Write(input)
+	failOnError(t, "Failed writing to compress object", err)
+	failOnError(t, "Failed closing writer", w.Close())
+
+	r := NewReader(bytes.NewReader(compressed.Bytes()))
+	defer r.Close()
+	n, err := r.Read(nil)
+	if n != 0 || err != nil {
+		t.Fatalf("Empty read should return 0, nil: %v, %v", n, err)
+	}
+	var output bytes.Buffer
+	dst := make([]byte, 5)
+	for {
+		_, err := io.ReadFull(r, dst)
+		if err == io.EOF {
+			break
+		}
+		failOnError(t, "Failed to decompress", err)
+		output.Write(dst)
+	}
+	if !bytes.Equal(input, output.Bytes()) {
+		t.Fatalf("Decompressed output != input (lengths: %v bytes & %v bytes)", output.Len(), len(input))
+	}
+
+	// bufio.Reader hands out lines spanning blocks.
+	r = NewReader(bytes.NewReader(compressed.Bytes()))
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	lines := 0
+	for scanner.Scan() {
+		if scanner.Text() != "Hello world, this is quite something" {
+			t.Fatalf("Unexpected line %d: %q", lines, scanner.Text())
+		}
+		lines++
+	}
+	failOnError(t, "Failed scanning", scanner.Err())
+	if lines != 5000 {
+		t.Fatalf("Read %d lines, want 5000", lines)
+	}
+}
+
+func TestStreamJSONDecoder(t *testing.T) {
+	type event struct {
+		ID   int
+		Host string
+	}
+
+	var compressed bytes.Buffer
+	w := NewWriter(&compressed)
+	enc := json.NewEncoder(w)
+	for i := 0; i < 10000; i++ {
+		failOnError(t, "Failed encoding", enc.Encode(event{i, "host-" + strconv.Itoa(i%7)}))
+	}
+	failOnError(t, "Failed closing writer", w.Close())
+
+	r := NewReader(&compressed)
+	defer r.Close()
+	dec := json.NewDecoder(r)
+	for i := 0; i < 10000; i++ {
+		var e event
+		failOnError(t, "Failed decoding", dec.Decode(&e))
+		if e.ID != i || e.Host != "host-"+strconv.Itoa(i%7) {
+			t.Fatalf("Decoded event != expected: %+v", e)
+		}
+	}
+	var e event
+	if err := dec.Decode(&e); err != io.EOF {
+		t.Fatalf("Error should have been EOF, was %v instead", err)
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	var compressed bytes.Buffer
+	w := NewWriter(&compressed)
+	_, err := w.Write([]byte(strings.Repeat("Hello world, this is quite something", 100)))
+	failOnError(t, "Failed writing to compress object", err)
+	failOnError(t, "Failed closing writer", w.Close())
+
+	r := NewReader(bytes.NewReader(compressed.Bytes()[:compressed.Len()-1]))
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Error should have been ErrTruncated, was %v instead", err)
+	}
+
+	r = NewReader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Fatal("Reading an oversized block should have failed")
+	}
+}
+
+func TestStreamFlush(t *testing.T) {
+	var compressed bytes.Buffer
+	w := NewWriter(&compressed)
+	defer w.Close()
+	r := NewReader(&compressed)
+	defer r.Close()
+
+	// Each flushed message can be read before the writer is closed.
+	dst := make([]byte, 64)
+	for _, msg := range []string{"first message", "second message", "third"} {
+		_, err := w.Write([]byte(msg))
+		failOnError(t, "Failed writing to compress object", err)
+		failOnError(t, "Failed flushing writer", w.Flush())
+		n, err := r.Read(dst)
+		failOnError(t, "Failed to decompress", err)
+		if string(dst[:n]) != msg {
+			t.Fatalf("Did not read the same %q != %q", dst[:n], msg)
+		}
+	}
+	failOnError(t, "Flushing nothing should not fail", w.Flush())
+}
+
+// streamFlushAfterFullBlock writes two full blocks, flushes a short one,
+// then repeats half of the second block.  Each block must only refer back to
+// the previous one, since that is all the reader keeps, even though the
+// second block starts like the second half of the first.
+func streamFlushAfterFullBlock(t *testing.T, w *Writer, compressed *bytes.Buffer) {
+	half := streamingBlockSize / 2
+	rnd := rand.New(rand.NewSource(1))
+	input := make([]byte, 3*streamingBlockSize)
+	rnd.Read(input)
+	second := input[streamingBlockSize : 2*streamingBlockSize]
+	copy(second, input[half:half+16])
+	copy(second[half:], second[:half])
+	input = append(input[:2*streamingBlockSize], "0123456789"...)
+	flushed := len(input)
+	input = append(input, second[half:]...)
+
+	_, err := w.Write(input[:flushed])
+	failOnError(t, "Failed writing to compress object", err)
+	failOnError(t, "Failed flushing writer", w.Flush())
+	_, err = w.Write(input[flushed:])
+	failOnError(t, "Failed writing to compress object", err)
+	failOnError(t, "Failed closing writer", w.Close())
+
+	r := NewReader(compressed)
+	defer r.Close()
+	output, err := ioutil.ReadAll(r)
+	failOnError(t, "Failed to decompress", err)
+	if !bytes.Equal(output, input) {
+		t.Fatalf("Decompressed output != input")
+	}
+}
+
+func TestStreamFlushAfterFullBlock(t *testing.T) {
+	var compressed bytes.Buffer
+	streamFlushAfterFullBlock(t, NewWriter(&compressed), &compressed)
+}
+
+func TestStreamEndMarker(t *testing.T) {
+	var compressed bytes.Buffer
+	w := NewWriter(&compressed)
+	failOnError(t, "Failed closing writer", w.Close())
+	if !bytes.Equal(compressed.Bytes(), []byte{0, 0, 0, 0}) {
+		t.Fatalf("Empty stream should only hold the end marker, got %v", compressed.Bytes())
+	}
+	if err := w.Flush(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Flushing a closed writer should have failed with ErrClosed, got %v", err)
+	}
+	if _, err := w.Write([]byte("late")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Writing to a closed writer should have failed with ErrClosed, got %v", err)
+	}
+	r := NewReader(bytes.NewReader(compressed.Bytes()))
+	r.Close()
+	if _, err := r.Read(make([]byte, 10)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Reading from a closed reader should have failed with ErrClosed, got %v", err)
+	}
+
+	w = NewWriter(&compressed)
+	_, err := w.Write([]byte(strings.Repeat("Hello world, this is quite something", 100)))
+	failOnError(t, "Failed writing to compress object", err)
+	failOnError(t, "Failed closing writer", w.Close())
+	stream := compressed.Bytes()[4:]
+
+	r = NewReader(bytes.NewReader(stream))
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	failOnError(t, "Failed to decompress", err)
+
+	// Without the end marker, the stream was truncated, like the streams
+	// written before there was an end marker, but all the data is returned.
+	r = NewReader(bytes.NewReader(stream[:len(stream)-4]))
+	defer r.Close()
+	output, err := ioutil.ReadAll(r)
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Error should have been ErrTruncated, was %v instead", err)
+	}
+	if string(output) != strings.Repeat("Hello world, this is quite something", 100) {
+		t.Fatalf("Data before the missing end marker should have been returned")
+	}
+	r = NewReader(bytes.NewReader(nil))
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Error should have been ErrTruncated, was %v instead", err)
+	}
+}
+
 func TestStreamingFuzz(t *testing.T) {
 	f := func(input []byte) bool {
 		var w bytes.Buffer
@@ -425,7 +977,10 @@ func TestStreamingFuzz(t *testing.T) {
 		dst := make([]byte, len(input))
 		n, err = r.Read(dst)
 
-		failOnError(t, "Failed Read", err)
+		// Writing nothing produces no block, so there is nothing to read.
+		if err != io.EOF || len(input) > 0 {
+			failOnError(t, "Failed Read", err)
+		}
 
 		dst = dst[:n]
 		if string(input) != string(dst) { // Only print if we can print
@@ -453,6 +1008,99 @@ func TestStreamingFuzz(t *testing.T) {
 	}
 }
 
+func TestStreamReset(t *testing.T) {
+	input, err := ioutil.ReadFile("sample.txt")
+	failOnError(t, "Failed reading sample", err)
+	input = bytes.Repeat(input, 50)
+
+	newWriters := map[string]func(io.Writer) *Writer{
+		"NewWriter":   NewWriter,
+		"NewWriterHC": NewWriterHC,
+		"NewWriterDict": func(w io.Writer) *Writer {
+			return NewWriterDict(w, jsonDictionary())
+		},
+	}
+	for name, newWriter := range newWriters {
+		var first, second bytes.Buffer
+		w := newWriter(&first)
+		_, err := w.Write(input)
+		failOnError(t, "Failed writing to compress object", err)
+		failOnError(t, "Failed closing writer", w.Close())
+
+		// Reset after Close, and in the middle of a stream.
+		for _, closed := range []bool{true, false} {
+			if !closed {
+				w.Reset(ioutil.Discard)
+				_, err = w.Write(input[:streamingBlockSize+1])
+				failOnError(t, "Failed writing to compress object", err)
+			}
+			second.Reset()
+			w.Reset(&second)
+			_, err = w.Write(input)
+			failOnError(t, "Failed writing to compress object", err)
+			failOnError(t, "Failed closing writer", w.Close())
+			if !bytes.Equal(first.Bytes(), second.Bytes()) {
+				t.Errorf("%s: stream written after Reset differs from the first one (closed: %v)", name, closed)
+			}
+		}
+	}
+
+	var compressed bytes.Buffer
+	w := NewWriter(&compressed)
+	_, err = w.Write(input)
+	failOnError(t, "Failed writing to compress object", err)
+	failOnError(t, "Failed closing writer", w.Close())
+
+	r := NewReader(bytes.NewReader(compressed.Bytes()))
+	defer r.Close()
+	dst := make([]byte, streamingBlockSize+1)
+	_, err = io.ReadFull(r, dst)
+	failOnError(t, "Failed to decompress", err)
+	for i := 0; i < 3; i++ {
+		r.(Resetter).Reset(bytes.NewReader(compressed.Bytes()))
+		output, err := ioutil.ReadAll(r)
+		failOnError(t, "Failed to decompress after Reset", err)
+		if !bytes.Equal(input, output) {
+			t.Fatalf("Decompressed output != input after Reset")
+		}
+		if i == 1 {
+			failOnError(t, "Failed closing reader", r.Close())
+		}
+	}
+}
+
+func TestStreamResetAllocs(t *testing.T) {
+	input, err := ioutil.ReadFile("sample.txt")
+	failOnError(t, "Failed reading sample", err)
+	input = bytes.Repeat(input, 50)
+	var compressed bytes.Buffer
+	w := NewWriter(&compressed)
+	r := NewReader(nil)
+	defer r.Close()
+	src := bytes.NewReader(nil)
+	output := make([]byte, len(input)+1)
+
+	allocs := testing.AllocsPerRun(10, func() {
+		compressed.Reset()
+		w.Reset(&compressed)
+		if _, err := w.Write(input); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		src.Reset(compressed.Bytes())
+		r.(Resetter).Reset(src)
+		if n, err := io.ReadFull(r, output); n != len(input) || err != io.ErrUnexpectedEOF {
+			t.Fatalf("Failed to decompress: %d bytes, %v", n, err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("Reusing a Writer and a reader should not allocate, but did %v times", allocs)
+	}
+}
+
 func BenchmarkCompress(b *testing.B) {
 	b.ReportAllocs()
 	dst := make([]byte, CompressBound(plaintext0))
@@ -524,7 +1172,7 @@ func BenchmarkStreamUncompress(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		r := NewReader(&buffer)
+		r := NewReader(bytes.NewReader(buffer.Bytes()))
 		for {
 			read, err := r.Read(localBuffer)
 			if err == io.EOF {
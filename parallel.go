@@ -0,0 +1,784 @@
+package lz4
+
+// parallel.go compresses and decompresses LZ4 frames on several goroutines
+// at once.  The blocks of such frames are independent, so they can be
+// processed in any order; they are written out and read back in the order of
+// the stream.
+
+// #define XXH_STATIC_LINKING_ONLY
+// #include "src/lz4frame.h"
+// #include "src/xxhash.h"
+import "C"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+const (
+	// lz4hcMinLevel is the lowest compression level of a frame that is
+	// compressed with LZ4HC.  See LZ4HC_CLEVEL_MIN in frame.go.
+	lz4hcMinLevel = 3
+	// uncompressedBlockFlag is set in the size of frame blocks that are
+	// stored uncompressed.
+	uncompressedBlockFlag = 0x80000000
+)
+
+// frameHeader returns the header of a frame with prefs.
+func frameHeader(prefs C.LZ4F_preferences_t) ([]byte, error) {
+	// The level does not show in the header, but an HC level would make
+	// lz4frame allocate an HC context.
+	prefs.compressionLevel = 0
+
+	var ctx *C.LZ4F_cctx
+	C.LZ4F_createCompressionContext(&ctx, C.LZ4F_VERSION)
+	defer C.LZ4F_freeCompressionContext(ctx)
+
+	header := make([]byte, C.LZ4F_HEADER_SIZE_MAX)
+	n := C.LZ4F_compressBegin(ctx, unsafe.Pointer(&header[0]), C.size_t(len(header)), &prefs)
+	if C.LZ4F_isError(n) != 0 {
+		return nil, frameError(n)
+	}
+	return header[:n], nil
+}
+
+// frameBlockEncoder encodes independent blocks of an LZ4 frame the way
+// lz4frame does.
+type frameBlockEncoder struct {
+	level    int
+	dict     *Dictionary
+	checksum bool
+}
+
+// frameBlockBound returns the most bytes encode writes for size bytes of
+// input.
+func frameBlockBound(size int) int {
+	return 4 + size + 4
+}
+
+// encode compresses src, which must not be empty, into dst as a block of a
+// frame, and returns the number of bytes written.  c holds the compression
+// state.  dst must be at least frameBlockBound(len(src)) bytes long.
+func (e *frameBlockEncoder) encode(c *Compressor, dst, src []byte) int {
+	// Blocks that do not get smaller are stored uncompressed.
+	out := dst[4 : 4+len(src)-1]
+	acceleration := 1
+	if e.level < 0 {
+		acceleration = -e.level + 1
+	}
+	var n int
+	var err error
+	switch {
+	case e.level < lz4hcMinLevel && e.dict == nil:
+		n, err = c.CompressFast(out, src, acceleration)
+	case e.level < lz4hcMinLevel:
+		n, err = compressDictFast(out, src, e.dict, acceleration)
+	case e.dict == nil:
+		n, err = c.CompressHCLevel(out, src, e.level)
+	default:
+		n, err = CompressDictHCLevel(out, src, e.dict, e.level)
+	}
+	size := uint32(n)
+	if err != nil {
+		n = copy(dst[4:], src)
+		size = uint32(n) | uncompressedBlockFlag
+	}
+	binary.LittleEndian.PutUint32(dst, size)
+	if e.checksum {
+		binary.LittleEndian.PutUint32(dst[4+n:], uint32(C.XXH32(unsafe.Pointer(&dst[4]), C.size_t(n), 0)))
+		n += 4
+	}
+	return 4 + n
+}
+
+// ParallelOptions controls how a ParallelFrameWriter compresses its input.
+type ParallelOptions struct {
+	// FrameOptions are the options of the frame.  BlockMode is ignored: the
+	// blocks are always independent.
+	FrameOptions
+	// Concurrency is the number of goroutines compressing blocks.  0 or less
+	// means runtime.GOMAXPROCS(0).
+	Concurrency int
+	// MaxBlocks is the most blocks held in memory at once, whether they are
+	// being filled, compressed or written out.  Each takes about twice the
+	// block size.  0 or less means twice Concurrency.
+	MaxBlocks int
+}
+
+// parallelBlock is a block of a ParallelFrameWriter.
+type parallelBlock struct {
+	data []byte
+	// out is data encoded as a frame block, and compressed is signalled
+	// once it is ready.
+	out        []byte
+	compressed chan struct{}
+}
+
+// ParallelFrameWriter is an io.WriteCloser that compresses its input into a
+// single LZ4 frame like FrameWriter, but compresses its blocks on several
+// goroutines at once.  Its output can be read by FrameReader and any other
+// LZ4 frame decoder.  Write only blocks when all the blocks allowed by
+// ParallelOptions.MaxBlocks are in use.
+type ParallelFrameWriter struct {
+	underlyingWriter io.Writer
+	prefs            C.LZ4F_preferences_t
+	encoder          frameBlockEncoder
+	blockSize        int
+	autoFlush        bool
+	contentChecksum  bool
+	contentSize      uint64
+	// written is the number of bytes written so far, and hash their
+	// checksum.
+	written uint64
+	hash    C.XXH32_state_t
+
+	// block is the block being filled.  free holds the blocks not in use,
+	// jobs the blocks waiting to be compressed, and queue the blocks waiting
+	// to be written out, in order.  pending counts the blocks in queue.
+	block   *parallelBlock
+	free    chan *parallelBlock
+	jobs    chan *parallelBlock
+	queue   chan *parallelBlock
+	pending sync.WaitGroup
+
+	// mu guards err, the first error writing to underlyingWriter.
+	mu  sync.Mutex
+	err error
+
+	wroteHeader bool
+	closed      bool
+	trailer     [8]byte
+	// onBlock, if set, is called with the encoded and the uncompressed size
+	// of every block, in order, once it is written out.
+	onBlock func(encoded, uncompressed int)
+}
+
+// NewParallelFrameWriter creates a new ParallelFrameWriter that compresses
+// according to opts.  Writes to the returned writer are compressed and
+// written to w as an LZ4 frame.  It is the caller's responsibility to call
+// Close on the ParallelFrameWriter when done, as this writes the end of the
+// frame and stops its goroutines.  It returns an error if opts are invalid.
+func NewParallelFrameWriter(w io.Writer, opts ParallelOptions) (*ParallelFrameWriter, error) {
+	frameOpts := opts.FrameOptions
+	frameOpts.BlockMode = BlockIndependent
+	prefs, err := frameOpts.preferences()
+	if err != nil {
+		return nil, err
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	maxBlocks := opts.MaxBlocks
+	if maxBlocks <= 0 {
+		maxBlocks = 2 * concurrency
+	}
+
+	pw := &ParallelFrameWriter{
+		underlyingWriter: w,
+		prefs:            prefs,
+		encoder: frameBlockEncoder{
+			level:    opts.CompressionLevel,
+			dict:     opts.Dictionary,
+			checksum: opts.BlockChecksum,
+		},
+		blockSize:       opts.BlockSize.bytes(),
+		autoFlush:       opts.AutoFlush,
+		contentChecksum: opts.ContentChecksum,
+		contentSize:     opts.ContentSize,
+		free:            make(chan *parallelBlock, maxBlocks),
+		jobs:            make(chan *parallelBlock, maxBlocks),
+		queue:           make(chan *parallelBlock, maxBlocks),
+	}
+	// The buffers of the blocks are only allocated when they are needed.
+	for i := 0; i < maxBlocks; i++ {
+		pw.free <- &parallelBlock{compressed: make(chan struct{}, 1)}
+	}
+	for i := 0; i < concurrency; i++ {
+		go pw.compressBlocks()
+	}
+	go pw.writeBlocks()
+	return pw, nil
+}
+
+// compressBlocks compresses the blocks from jobs until it is closed.
+func (w *ParallelFrameWriter) compressBlocks() {
+	var c Compressor
+	for b := range w.jobs {
+		b.out = b.out[:w.encoder.encode(&c, b.out[:cap(b.out)], b.data)]
+		b.compressed <- struct{}{}
+	}
+}
+
+// writeBlocks writes out the blocks from queue, in order, until it is
+// closed.
+func (w *ParallelFrameWriter) writeBlocks() {
+	for b := range w.queue {
+		<-b.compressed
+		if w.error() == nil {
+			if _, err := w.underlyingWriter.Write(b.out); err != nil {
+				w.mu.Lock()
+				w.err = err
+				w.mu.Unlock()
+			} else if w.onBlock != nil {
+				w.onBlock(len(b.out), len(b.data))
+			}
+		}
+		b.data = b.data[:0]
+		w.free <- b
+		w.pending.Done()
+	}
+}
+
+// error returns the first error writing out blocks, if any.
+func (w *ParallelFrameWriter) error() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// begin writes the frame header if it has not been written yet.  No block
+// is being written out before the header is.
+func (w *ParallelFrameWriter) begin() error {
+	if w.closed {
+		return ErrClosed
+	}
+	if err := w.error(); err != nil {
+		return err
+	}
+	if w.wroteHeader {
+		return nil
+	}
+	header, err := frameHeader(w.prefs)
+	if err != nil {
+		return err
+	}
+	if _, err := w.underlyingWriter.Write(header); err != nil {
+		return err
+	}
+	C.XXH32_reset(&w.hash, 0)
+	w.wroteHeader = true
+	return nil
+}
+
+// Write buffers src and hands every full block to the compressing
+// goroutines.  Data may be buffered until a block is full; use Flush to
+// force it out.  Errors writing to the underlying io.Writer may only be
+// returned by a later Write, Flush or Close.
+func (w *ParallelFrameWriter) Write(src []byte) (int, error) {
+	if err := w.begin(); err != nil {
+		return 0, err
+	}
+	if w.contentChecksum && len(src) > 0 {
+		C.XXH32_update(&w.hash, unsafe.Pointer(&src[0]), C.size_t(len(src)))
+	}
+	w.written += uint64(len(src))
+
+	written := 0
+	for len(src) > 0 {
+		if w.block == nil {
+			w.block = <-w.free
+			if w.block.data == nil {
+				w.block.data = make([]byte, 0, w.blockSize)
+				w.block.out = make([]byte, 0, frameBlockBound(w.blockSize))
+			}
+		}
+		n := len(src)
+		if room := w.blockSize - len(w.block.data); n > room {
+			n = room
+		}
+		w.block.data = append(w.block.data, src[:n]...)
+		written += n
+		src = src[n:]
+
+		if len(w.block.data) == w.blockSize {
+			w.submit()
+			if err := w.error(); err != nil {
+				return written, err
+			}
+		}
+	}
+	if w.autoFlush {
+		return written, w.Flush()
+	}
+	return written, nil
+}
+
+// submit hands the block being filled to the compressing goroutines, and to
+// the goroutine writing them out.
+func (w *ParallelFrameWriter) submit() {
+	b := w.block
+	if b == nil {
+		return
+	}
+	w.block = nil
+	if len(b.data) == 0 {
+		w.free <- b
+		return
+	}
+	w.pending.Add(1)
+	w.queue <- b
+	w.jobs <- b
+}
+
+// Flush compresses any buffered data into a block and waits until all
+// blocks are written to the underlying io.Writer.
+func (w *ParallelFrameWriter) Flush() error {
+	if err := w.begin(); err != nil {
+		return err
+	}
+	w.submit()
+	w.pending.Wait()
+	return w.error()
+}
+
+// Close flushes any buffered data, writes the end of the frame and stops the
+// goroutines of w.  It does not close the underlying io.Writer.
+func (w *ParallelFrameWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	if err := w.Flush(); err != nil {
+		w.stop()
+		return err
+	}
+	w.stop()
+
+	if w.contentSize != 0 && w.written != w.contentSize {
+		return fmt.Errorf("lz4: frame content size is %d, but %d bytes were written", w.contentSize, w.written)
+	}
+	// A 0 block size marks the end of the frame.
+	end := w.trailer[:4]
+	binary.LittleEndian.PutUint32(end, 0)
+	if w.contentChecksum {
+		end = w.trailer[:8]
+		binary.LittleEndian.PutUint32(end[4:], uint32(C.XXH32_digest(&w.hash)))
+	}
+	_, err := w.underlyingWriter.Write(end)
+	return err
+}
+
+// stop stops the goroutines of w.  No block may be pending.
+func (w *ParallelFrameWriter) stop() {
+	w.closed = true
+	close(w.jobs)
+	close(w.queue)
+}
+
+// parallelItemKind tells what a parallelItem is.
+type parallelItemKind int
+
+const (
+	// itemBlock is a block of data.
+	itemBlock parallelItemKind = iota
+	// itemFrameStart is the header of a frame.
+	itemFrameStart
+	// itemFrameEnd is the end of a frame, with its content checksum.
+	itemFrameEnd
+	// itemSkippable is a skippable frame.
+	itemSkippable
+	// itemLinked is a frame with linked blocks, which cannot be decompressed
+	// in parallel.
+	itemLinked
+	// itemError is an error reading the underlying io.Reader.
+	itemError
+)
+
+// parallelItem is something read by a ParallelFrameReader, which Read hands
+// out in the order it was read.
+type parallelItem struct {
+	kind parallelItemKind
+
+	// src is the compressed data of a block, without its checksum, and data
+	// the decompressed data, which is as long as the largest block of the
+	// frame until then.  decompressed is signalled once data is ready, or err
+	// set.
+	src           []byte
+	data          []byte
+	uncompressed  bool
+	blockChecksum bool
+	checksum      uint32
+	dict          *Dictionary
+	decompressed  chan struct{}
+
+	// info is the header of the frame for itemFrameStart.  checksum is the
+	// content checksum for itemFrameEnd, if info.ContentChecksum is set.
+	info FrameInfo
+	// skippable and id are the content and id of a skippable frame.
+	skippable []byte
+	id        int
+	// rest is the rest of the stream for itemLinked, from the start of the
+	// linked frame.
+	rest io.Reader
+	err  error
+}
+
+// decompress decompresses the block in item into its data.
+func (item *parallelItem) decompress() error {
+	n, err := decodeFrameBlock(item.data, item.src, item.uncompressed, item.blockChecksum, item.checksum, item.dict)
+	item.data = item.data[:n]
+	return err
+}
+
+// decodeFrameBlock decompresses src, the data of an independent frame block,
+// into dst and returns the number of bytes written.  If blockChecksum is set,
+// checksum is the checksum of src.
+func decodeFrameBlock(dst, src []byte, uncompressed, blockChecksum bool, checksum uint32, dict *Dictionary) (int, error) {
+	if blockChecksum && uint32(C.XXH32(unsafe.Pointer(p(src)), C.size_t(len(src)), 0)) != checksum {
+		return 0, fmt.Errorf("%w: block checksum", ErrChecksum)
+	}
+	if uncompressed {
+		if len(src) > len(dst) {
+			return 0, fmt.Errorf("%w: block is too large", ErrCorrupt)
+		}
+		return copy(dst, src), nil
+	}
+	var n int
+	var err error
+	if dict != nil {
+		n, err = UncompressDict(dst, src, dict)
+	} else {
+		n, err = Uncompress(dst, src)
+	}
+	if err != nil {
+		// Blocks that do not fit are as malformed as any other.
+		return 0, ErrCorrupt
+	}
+	return n, nil
+}
+
+// ParallelFrameReader is an io.ReadCloser that decompresses a stream of LZ4
+// frames like FrameReader, but reads ahead and decompresses the blocks of
+// frames with independent blocks on several goroutines at once.  Frames with
+// linked blocks, and any that follow them, are decompressed on the calling
+// goroutine, by a FrameReader.
+type ParallelFrameReader struct {
+	underlyingReader *bufio.Reader
+	concurrency      int
+	onSkippable      SkippableFrameFunc
+	dicts            *DictionaryRegistry
+
+	// free holds the blocks not in use, and bounds the read ahead.  jobs
+	// holds the blocks waiting to be decompressed, and queue everything that
+	// was read, in order.  done is closed by Close.
+	free    chan *parallelItem
+	jobs    chan *parallelItem
+	queue   chan *parallelItem
+	done    chan struct{}
+	started bool
+
+	// item is the item being returned by Read, pos how much of its data was
+	// returned so far, and info, size and hash the header, content size
+	// and content checksum of the current frame.
+	item *parallelItem
+	pos  int
+	info FrameInfo
+	size uint64
+	hash C.XXH32_state_t
+
+	// linked reads the stream once a frame with linked blocks is found.
+	linked *FrameReader
+	err    error
+	closed bool
+}
+
+// NewParallelFrameReader creates a new ParallelFrameReader.  Reads from the
+// returned reader read and decompress data from r.  concurrency is the
+// number of goroutines decompressing blocks, and maxBlocks the most blocks
+// that are read ahead and held in memory at once.  0 or less means
+// runtime.GOMAXPROCS(0) goroutines, and twice as many blocks.  It is the
+// caller's responsibility to call Close on the ParallelFrameReader when
+// done, to stop its goroutines.
+func NewParallelFrameReader(r io.Reader, concurrency, maxBlocks int) *ParallelFrameReader {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	if maxBlocks <= 0 {
+		maxBlocks = 2 * concurrency
+	}
+	pr := &ParallelFrameReader{
+		underlyingReader: bufio.NewReaderSize(r, frameChunkSize),
+		concurrency:      concurrency,
+		free:             make(chan *parallelItem, maxBlocks),
+		jobs:             make(chan *parallelItem, maxBlocks),
+		queue:            make(chan *parallelItem, maxBlocks),
+		done:             make(chan struct{}),
+	}
+	// The buffers of the blocks are only allocated when they are needed.
+	for i := 0; i < maxBlocks; i++ {
+		pr.free <- &parallelItem{decompressed: make(chan struct{}, 1)}
+	}
+	return pr
+}
+
+// OnSkippableFrame sets fn to be called for every skippable frame, like
+// FrameReader.OnSkippableFrame.  It must be called before the first Read.
+func (r *ParallelFrameReader) OnSkippableFrame(fn SkippableFrameFunc) {
+	r.onSkippable = fn
+}
+
+// SetDictionaries sets the registry in which the dictionaries of frames are
+// looked up, like FrameReader.SetDictionaries.  It must be called before the
+// first Read.
+func (r *ParallelFrameReader) SetDictionaries(dicts *DictionaryRegistry) {
+	r.dicts = dicts
+}
+
+// readFrames reads the underlying io.Reader and sends what it reads to
+// queue, and the blocks to jobs, until the stream ends, fails or the reader
+// is closed.
+func (r *ParallelFrameReader) readFrames() {
+	defer close(r.queue)
+	defer close(r.jobs)
+
+	for {
+		var magic [4]byte
+		if _, err := io.ReadFull(r.underlyingReader, magic[:]); err != nil {
+			if err != io.EOF {
+				r.fail(unexpectedEOF(err))
+			}
+			return
+		}
+		if binary.LittleEndian.Uint32(magic[:])&skippableFrameMask == skippableFrameMagic {
+			if !r.readSkippableFrame(magic[:]) {
+				return
+			}
+			continue
+		}
+
+		// Keep the header, in case the frame has to be handed to a
+		// FrameReader.
+		var header bytes.Buffer
+		info, err := ReadFrameInfo(io.TeeReader(io.MultiReader(bytes.NewReader(magic[:]), r.underlyingReader), &header))
+		if err != nil {
+			r.fail(err)
+			return
+		}
+		if info.BlockMode == BlockLinked {
+			r.send(&parallelItem{kind: itemLinked, rest: io.MultiReader(&header, r.underlyingReader)})
+			return
+		}
+		if !r.send(&parallelItem{kind: itemFrameStart, info: info}) || !r.readBlocks(info) {
+			return
+		}
+	}
+}
+
+// readSkippableFrame reads the skippable frame starting with magic, and
+// reports whether the reader should go on.
+func (r *ParallelFrameReader) readSkippableFrame(magic []byte) bool {
+	var size [4]byte
+	if _, err := io.ReadFull(r.underlyingReader, size[:]); err != nil {
+		return r.fail(unexpectedEOF(err))
+	}
+	n := int64(binary.LittleEndian.Uint32(size[:]))
+	if r.onSkippable == nil {
+		if _, err := io.CopyN(ioutil.Discard, r.underlyingReader, n); err != nil {
+			return r.fail(unexpectedEOF(err))
+		}
+		return true
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.underlyingReader, data); err != nil {
+		return r.fail(unexpectedEOF(err))
+	}
+	id := int(binary.LittleEndian.Uint32(magic) &^ skippableFrameMask)
+	return r.send(&parallelItem{kind: itemSkippable, skippable: data, id: id})
+}
+
+// readBlocks reads the blocks of a frame with info up to the end of the
+// frame, and reports whether the reader should go on.
+func (r *ParallelFrameReader) readBlocks(info FrameInfo) bool {
+	var dict *Dictionary
+	if info.DictID != 0 {
+		if dict = r.dicts.Lookup(info.DictID); dict == nil {
+			return r.fail(fmt.Errorf("%w %d", ErrUnknownDictionary, info.DictID))
+		}
+	}
+	blockSize := info.BlockSize.bytes()
+
+	var word [4]byte
+	readWord := func() (uint32, error) {
+		_, err := io.ReadFull(r.underlyingReader, word[:])
+		return binary.LittleEndian.Uint32(word[:]), unexpectedEOF(err)
+	}
+	for {
+		size, err := readWord()
+		if err != nil {
+			return r.fail(err)
+		}
+		if size == 0 {
+			end := &parallelItem{kind: itemFrameEnd}
+			if info.ContentChecksum {
+				if end.checksum, err = readWord(); err != nil {
+					return r.fail(err)
+				}
+			}
+			return r.send(end)
+		}
+		if int(size&^uncompressedBlockFlag) > blockSize {
+			return r.fail(fmt.Errorf("%w: block is too large", ErrCorrupt))
+		}
+
+		var item *parallelItem
+		select {
+		case item = <-r.free:
+		case <-r.done:
+			return false
+		}
+		if cap(item.data) < blockSize {
+			item.src = make([]byte, 0, blockSize)
+			item.data = make([]byte, 0, blockSize)
+		}
+		item.src = item.src[:size&^uncompressedBlockFlag]
+		item.data = item.data[:blockSize]
+		item.uncompressed = size&uncompressedBlockFlag != 0
+		item.blockChecksum = info.BlockChecksum
+		item.dict = dict
+		item.err = nil
+		_, err = io.ReadFull(r.underlyingReader, item.src)
+		if err == nil && info.BlockChecksum {
+			item.checksum, err = readWord()
+		}
+		if err != nil {
+			r.free <- item
+			return r.fail(unexpectedEOF(err))
+		}
+		r.jobs <- item
+		if !r.send(item) {
+			return false
+		}
+	}
+}
+
+// fail sends err to queue, and reports that the reader should stop.
+func (r *ParallelFrameReader) fail(err error) bool {
+	r.send(&parallelItem{kind: itemError, err: err})
+	return false
+}
+
+// send sends item to queue, and reports whether the reader should go on.
+func (r *ParallelFrameReader) send(item *parallelItem) bool {
+	select {
+	case r.queue <- item:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// decompressBlocks decompresses the blocks from jobs until it is closed.
+func (r *ParallelFrameReader) decompressBlocks() {
+	for item := range r.jobs {
+		item.err = item.decompress()
+		item.decompressed <- struct{}{}
+	}
+}
+
+// Read decompresses data from the underlying io.Reader into dst.  It returns
+// io.EOF when the underlying reader ends after a complete frame, and
+// ErrTruncated if it ends in the middle of a frame.
+func (r *ParallelFrameReader) Read(dst []byte) (int, error) {
+	if r.closed {
+		return 0, ErrClosed
+	}
+	if r.linked != nil {
+		return r.linked.Read(dst)
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(dst) == 0 {
+		return 0, nil
+	}
+	if !r.started {
+		r.started = true
+		go r.readFrames()
+		for i := 0; i < r.concurrency; i++ {
+			go r.decompressBlocks()
+		}
+	}
+
+	for r.item == nil || r.pos == len(r.item.data) {
+		if r.item != nil {
+			r.free <- r.item
+			r.item = nil
+		}
+		item, ok := <-r.queue
+		if !ok {
+			r.err = io.EOF
+			return 0, r.err
+		}
+		if r.err = r.next(item); r.err != nil {
+			return 0, r.err
+		}
+		if r.linked != nil {
+			return r.linked.Read(dst)
+		}
+	}
+
+	n := copy(dst, r.item.data[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// next processes the next item from queue.
+func (r *ParallelFrameReader) next(item *parallelItem) error {
+	switch item.kind {
+	case itemBlock:
+		<-item.decompressed
+		if err := item.err; err != nil {
+			r.free <- item
+			return err
+		}
+		r.item, r.pos = item, 0
+		r.size += uint64(len(item.data))
+		if r.info.ContentChecksum && len(item.data) > 0 {
+			C.XXH32_update(&r.hash, unsafe.Pointer(&item.data[0]), C.size_t(len(item.data)))
+		}
+	case itemFrameStart:
+		r.info = item.info
+		r.size = 0
+		C.XXH32_reset(&r.hash, 0)
+	case itemFrameEnd:
+		if r.info.ContentSize != 0 && r.size != r.info.ContentSize {
+			return fmt.Errorf("%w: frame content size is %d, but %d bytes were read", ErrCorrupt, r.info.ContentSize, r.size)
+		}
+		if r.info.ContentChecksum && uint32(C.XXH32_digest(&r.hash)) != item.checksum {
+			return fmt.Errorf("%w: content checksum", ErrChecksum)
+		}
+	case itemSkippable:
+		if r.onSkippable != nil {
+			return r.onSkippable(item.id, item.skippable)
+		}
+	case itemLinked:
+		r.linked = NewFrameReader(item.rest)
+		r.linked.OnSkippableFrame(r.onSkippable)
+		r.linked.SetDictionaries(r.dicts)
+	case itemError:
+		return item.err
+	}
+	return nil
+}
+
+// Close stops the goroutines of r and releases all the resources it
+// occupies.  A goroutine blocked reading the underlying io.Reader only stops
+// once that read returns.  It does not close the underlying io.Reader.
+func (r *ParallelFrameReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	if r.linked != nil {
+		r.linked.Close()
+	}
+	return nil
+}
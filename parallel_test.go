@@ -0,0 +1,336 @@
+package lz4
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+// parallelInput returns size bytes of compressible data with some
+// incompressible stretches, so that some blocks are stored uncompressed.
+func parallelInput(size int) []byte {
+	rnd := rand.New(rand.NewSource(1))
+	input := make([]byte, 0, size)
+	for i := 0; len(input) < size; i++ {
+		if i%2000 == 7 {
+			noise := make([]byte, 100000)
+			rnd.Read(noise)
+			input = append(input, noise...)
+		} else {
+			input = append(input, jsonEvent(i)...)
+		}
+	}
+	return input[:size]
+}
+
+func compressParallel(t *testing.T, input []byte, opts ParallelOptions) []byte {
+	var buf bytes.Buffer
+	w, err := NewParallelFrameWriter(&buf, opts)
+	failOnError(t, "Failed creating parallel frame writer", err)
+	// Write in odd sizes, so that writes straddle blocks.
+	for chunk := input; len(chunk) > 0; {
+		n := 12345
+		if n > len(chunk) {
+			n = len(chunk)
+		}
+		_, err := w.Write(chunk[:n])
+		failOnError(t, "Failed writing to parallel frame writer", err)
+		chunk = chunk[n:]
+	}
+	failOnError(t, "Failed closing parallel frame writer", w.Close())
+	return buf.Bytes()
+}
+
+func TestParallelFrameWriter(t *testing.T) {
+	input := parallelInput(5 << 20)
+	dict := jsonDictionary()
+	dicts := NewDictionaryRegistry()
+	failOnError(t, "Failed registering dictionary", dicts.Register(3, dict))
+
+	for _, opts := range []ParallelOptions{
+		{},
+		{Concurrency: 1, MaxBlocks: 1},
+		{Concurrency: 3, MaxBlocks: 2},
+		{FrameOptions: FrameOptions{BlockSize: BlockSize1MB, ContentChecksum: true, BlockChecksum: true}},
+		{FrameOptions: FrameOptions{BlockSize: BlockSize256KB, CompressionLevel: 9, ContentSize: uint64(len(input))}},
+		{FrameOptions: FrameOptions{CompressionLevel: -10, BlockMode: BlockLinked}},
+		{FrameOptions: FrameOptions{Dictionary: dict, DictID: 3, BlockChecksum: true}},
+		{FrameOptions: FrameOptions{Dictionary: dict, DictID: 3, CompressionLevel: 5}},
+	} {
+		compressed := compressParallel(t, input, opts)
+		if len(compressed) >= len(input)/2 {
+			t.Errorf("%+v: compressed size %d is too large", opts, len(compressed))
+		}
+
+		r := NewFrameReader(bytes.NewReader(compressed))
+		r.SetDictionaries(dicts)
+		info, err := r.FrameInfo()
+		failOnError(t, "Failed reading frame info", err)
+		if info.BlockMode != BlockIndependent || info.ContentChecksum != opts.ContentChecksum ||
+			info.BlockChecksum != opts.BlockChecksum || info.ContentSize != opts.ContentSize || info.DictID != opts.DictID {
+			t.Errorf("%+v: unexpected frame info %+v", opts, info)
+		}
+		output, err := ioutil.ReadAll(r)
+		failOnError(t, "Failed to decompress", err)
+		r.Close()
+		if !bytes.Equal(input, output) {
+			t.Fatalf("%+v: decompressed output != input", opts)
+		}
+	}
+}
+
+func TestParallelFrameWriterDeterministic(t *testing.T) {
+	input := parallelInput(3 << 20)
+	opts := ParallelOptions{FrameOptions: FrameOptions{BlockChecksum: true, ContentChecksum: true}}
+	want := compressParallel(t, input, opts)
+	for _, concurrency := range []int{1, 2, 16} {
+		opts.Concurrency = concurrency
+		if got := compressParallel(t, input, opts); !bytes.Equal(got, want) {
+			t.Errorf("Output with %d goroutines differs", concurrency)
+		}
+	}
+
+	// FrameWriter writes the same frame, as long as every block compresses.
+	input = bytes.Repeat([]byte(jsonEvent(1)), 20000)
+	var buf bytes.Buffer
+	fw, err := NewFrameWriterOptions(&buf, FrameOptions{BlockMode: BlockIndependent})
+	failOnError(t, "Failed creating frame writer", err)
+	_, err = fw.Write(input)
+	failOnError(t, "Failed writing to frame writer", err)
+	failOnError(t, "Failed closing frame writer", fw.Close())
+	if got := compressParallel(t, input, ParallelOptions{}); !bytes.Equal(got, buf.Bytes()) {
+		t.Errorf("ParallelFrameWriter and FrameWriter wrote different frames")
+	}
+}
+
+func TestParallelFrameWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewParallelFrameWriter(&buf, ParallelOptions{FrameOptions: FrameOptions{ContentChecksum: true}})
+	failOnError(t, "Failed creating parallel frame writer", err)
+	_, err = w.Write(nil)
+	failOnError(t, "Failed writing to parallel frame writer", err)
+	failOnError(t, "Failed flushing parallel frame writer", w.Flush())
+	failOnError(t, "Failed closing parallel frame writer", w.Close())
+	failOnError(t, "Failed closing parallel frame writer twice", w.Close())
+
+	r := NewFrameReader(&buf)
+	defer r.Close()
+	output, err := ioutil.ReadAll(r)
+	failOnError(t, "Failed to decompress", err)
+	if len(output) != 0 {
+		t.Fatalf("Empty frame decompressed to %d bytes", len(output))
+	}
+	if _, err := w.Write([]byte("x")); err != ErrClosed {
+		t.Errorf("Write after Close should have failed, got %v", err)
+	}
+}
+
+func TestParallelFrameWriterFlush(t *testing.T) {
+	input := parallelInput(1 << 20)
+	var buf bytes.Buffer
+	w, err := NewParallelFrameWriter(&buf, ParallelOptions{})
+	failOnError(t, "Failed creating parallel frame writer", err)
+	defer w.Close()
+	total := 0
+	for _, n := range []int{100, 300000, 1} {
+		_, err := w.Write(input[total : total+n])
+		failOnError(t, "Failed writing to parallel frame writer", err)
+		failOnError(t, "Failed flushing parallel frame writer", w.Flush())
+		total += n
+
+		// Everything written so far can be decompressed.
+		r := NewFrameReader(bytes.NewReader(buf.Bytes()))
+		output := make([]byte, total+1)
+		read, err := io.ReadFull(r, output)
+		r.Close()
+		if read != total || !bytes.Equal(output[:total], input[:total]) || !errors.Is(err, ErrTruncated) {
+			t.Fatalf("Reading flushed data gave %d bytes and %v", read, err)
+		}
+	}
+}
+
+func TestParallelFrameWriterErrors(t *testing.T) {
+	if _, err := NewParallelFrameWriter(ioutil.Discard, ParallelOptions{FrameOptions: FrameOptions{BlockSize: 3}}); err == nil {
+		t.Errorf("Invalid options should have been rejected")
+	}
+
+	w, err := NewParallelFrameWriter(ioutil.Discard, ParallelOptions{FrameOptions: FrameOptions{ContentSize: 10}})
+	failOnError(t, "Failed creating parallel frame writer", err)
+	_, err = w.Write([]byte("short"))
+	failOnError(t, "Failed writing to parallel frame writer", err)
+	if err := w.Close(); err == nil {
+		t.Errorf("Close should have failed on a content size mismatch")
+	}
+
+	input := parallelInput(2 << 20)
+	w, err = NewParallelFrameWriter(&failingWriter{left: 100000}, ParallelOptions{})
+	failOnError(t, "Failed creating parallel frame writer", err)
+	_, err = w.Write(input)
+	if err == nil {
+		err = w.Close()
+	} else {
+		w.Close()
+	}
+	if err != errFailingWriter {
+		t.Errorf("Write errors should have been returned, got %v", err)
+	}
+}
+
+func TestParallelFrameReader(t *testing.T) {
+	input := parallelInput(5 << 20)
+	dicts := NewDictionaryRegistry()
+	failOnError(t, "Failed registering dictionary", dicts.Register(3, jsonDictionary()))
+
+	for _, opts := range []ParallelOptions{
+		{},
+		{FrameOptions: FrameOptions{BlockSize: BlockSize4MB, ContentChecksum: true, BlockChecksum: true, ContentSize: uint64(len(input))}},
+		{FrameOptions: FrameOptions{Dictionary: jsonDictionary(), DictID: 3}},
+	} {
+		compressed := compressParallel(t, input, opts)
+		for _, concurrency := range []int{0, 1, 5} {
+			for _, maxBlocks := range []int{0, 1, 3} {
+				// Read the compressed data in small pieces, and the output in
+				// odd sizes.
+				r := NewParallelFrameReader(iotest.HalfReader(bytes.NewReader(compressed)), concurrency, maxBlocks)
+				r.SetDictionaries(dicts)
+				var output bytes.Buffer
+				_, err := io.CopyBuffer(&output, struct{ io.Reader }{r}, make([]byte, 9999))
+				failOnError(t, "Failed to decompress", err)
+				failOnError(t, "Failed closing parallel frame reader", r.Close())
+				if !bytes.Equal(input, output.Bytes()) {
+					t.Fatalf("%+v, %d goroutines, %d blocks: decompressed output != input", opts.FrameOptions, concurrency, maxBlocks)
+				}
+			}
+		}
+	}
+}
+
+func TestParallelFrameReaderConcatenated(t *testing.T) {
+	inputs := [][]byte{parallelInput(1 << 20), []byte("linked"), parallelInput(300000), parallelInput(100)}
+	var buf bytes.Buffer
+	buf.Write(compressParallel(t, inputs[0], ParallelOptions{}))
+	failOnError(t, "Failed writing skippable frame", WriteSkippableFrame(&buf, 1, []byte("first")))
+	buf.Write(compressParallel(t, nil, ParallelOptions{}))
+	w := NewFrameWriter(&buf)
+	_, err := w.Write(inputs[1])
+	failOnError(t, "Failed writing to frame writer", err)
+	failOnError(t, "Failed closing frame writer", w.Close())
+	failOnError(t, "Failed writing skippable frame", WriteSkippableFrame(&buf, 2, []byte("second")))
+	buf.Write(compressParallel(t, inputs[2], ParallelOptions{}))
+	buf.Write(compressParallel(t, inputs[3], ParallelOptions{}))
+
+	var output bytes.Buffer
+	var skipped []string
+	r := NewParallelFrameReader(&buf, 2, 2)
+	defer r.Close()
+	r.OnSkippableFrame(func(id int, data []byte) error {
+		skipped = append(skipped, fmt.Sprintf("%d:%s@%d", id, data, output.Len()))
+		return nil
+	})
+	_, err = io.Copy(&output, r)
+	failOnError(t, "Failed to decompress", err)
+	if !bytes.Equal(output.Bytes(), bytes.Join(inputs, nil)) {
+		t.Fatalf("Decompressed output != input")
+	}
+	want := []string{fmt.Sprintf("1:first@%d", len(inputs[0])), fmt.Sprintf("2:second@%d", len(inputs[0])+len(inputs[1]))}
+	if fmt.Sprint(skipped) != fmt.Sprint(want) {
+		t.Errorf("Skippable frames should have been %v, were %v", want, skipped)
+	}
+}
+
+func TestParallelFrameReaderErrors(t *testing.T) {
+	input := parallelInput(1 << 20)
+	opts := ParallelOptions{FrameOptions: FrameOptions{ContentChecksum: true}}
+	compressed := compressParallel(t, input, opts)
+	opts.BlockChecksum = true
+	checked := compressParallel(t, input, opts)
+	withDict := compressParallel(t, input, ParallelOptions{FrameOptions: FrameOptions{Dictionary: jsonDictionary(), DictID: 3}})
+
+	corrupt := func(b []byte, pos int) []byte {
+		b = append([]byte(nil), b...)
+		b[pos] ^= 0x55
+		return b
+	}
+	for _, test := range []struct {
+		name       string
+		compressed []byte
+		want       error
+	}{
+		{"truncated", compressed[:len(compressed)/2], ErrTruncated},
+		{"truncated end", compressed[:len(compressed)-1], ErrTruncated},
+		{"header checksum", corrupt(compressed, 6), ErrChecksum},
+		{"magic", corrupt(compressed, 0), ErrCorrupt},
+		{"block size", corrupt(compressed, 10), ErrCorrupt},
+		{"block", corrupt(compressed, 100), ErrChecksum},
+		{"block checksum", corrupt(checked, 100), ErrChecksum},
+		{"content checksum", corrupt(compressed, len(compressed)-1), ErrChecksum},
+	} {
+		r := NewParallelFrameReader(bytes.NewReader(test.compressed), 2, 2)
+		_, err := ioutil.ReadAll(r)
+		r.Close()
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: error should have been %v, was %v", test.name, test.want, err)
+		}
+	}
+
+	r := NewParallelFrameReader(bytes.NewReader(withDict), 2, 2)
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrUnknownDictionary) {
+		t.Errorf("Reading a frame with an unknown dictionary should have failed with ErrUnknownDictionary, got %v", err)
+	}
+	r.Close()
+
+	// Closing in the middle of the stream stops the goroutines reading ahead.
+	r = NewParallelFrameReader(bytes.NewReader(compressed), 2, 2)
+	_, err := r.Read(make([]byte, 10))
+	failOnError(t, "Failed to decompress", err)
+	failOnError(t, "Failed closing parallel frame reader", r.Close())
+	if _, err := r.Read(make([]byte, 10)); err != ErrClosed {
+		t.Errorf("Read after Close should have failed, got %v", err)
+	}
+}
+
+var errFailingWriter = errors.New("failing writer")
+
+// failingWriter fails once left bytes have been written to it.
+type failingWriter struct {
+	left int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.left {
+		return 0, errFailingWriter
+	}
+	w.left -= len(p)
+	return len(p), nil
+}
+
+func BenchmarkParallelFrameWriter(b *testing.B) {
+	input := parallelInput(16 << 20)
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w, _ := NewParallelFrameWriter(ioutil.Discard, ParallelOptions{})
+		w.Write(input)
+		w.Close()
+	}
+}
+
+func BenchmarkParallelFrameReader(b *testing.B) {
+	var buf bytes.Buffer
+	input := parallelInput(16 << 20)
+	w, _ := NewParallelFrameWriter(&buf, ParallelOptions{})
+	w.Write(input)
+	w.Close()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := NewParallelFrameReader(bytes.NewReader(buf.Bytes()), 0, 0)
+		io.Copy(ioutil.Discard, r)
+		r.Close()
+	}
+}
@@ -0,0 +1,303 @@
+package lz4
+
+// seekable.go reads and writes seekable streams, which allow random access
+// to compressed data.  A seekable stream is a regular LZ4 frame of
+// independent blocks, followed by a skippable frame holding a seek table:
+//
+//	skippable frame header  magic 0x184D2A5E, content size   (2 x 4 bytes)
+//	one entry per block     encoded size, uncompressed size  (2 x 4 bytes)
+//	footer                  number of blocks, seekableMagic  (2 x 4 bytes)
+//
+// All values are little endian.  The encoded size of a block includes its
+// block size field and checksum.  Since the footer is at the very end, the
+// seek table can be found without reading anything else, and LZ4 decoders
+// that know nothing about it skip it like any other skippable frame.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	// seekableFrameID is the id of the skippable frame holding the seek
+	// table.
+	seekableFrameID = 0xE
+	// seekableMagic ends a seekable stream.
+	seekableMagic = 0x8F92EA4C
+)
+
+// SeekableWriter is an io.WriteCloser that compresses its input into a
+// seekable stream, which SeekableReader can read from at any offset.  Blocks
+// are compressed on several goroutines, like with ParallelFrameWriter.  The
+// output can also be read by FrameReader and any other LZ4 frame decoder.
+type SeekableWriter struct {
+	*ParallelFrameWriter
+	underlyingWriter io.Writer
+	// table holds the entries of the seek table, and blocks counts them.
+	table  []byte
+	blocks int
+}
+
+// NewSeekableWriter creates a new SeekableWriter that compresses according
+// to opts.  Random access goes down to the block, so smaller blocks make
+// random reads cheaper, at the cost of compression ratio.  Dictionaries are
+// not supported.  It is the caller's responsibility to call Close on the
+// SeekableWriter when done, as this writes the end of the frame and the seek
+// table.  It returns an error if opts are invalid.
+func NewSeekableWriter(w io.Writer, opts ParallelOptions) (*SeekableWriter, error) {
+	if opts.Dictionary != nil {
+		return nil, errors.New("lz4: seekable streams do not support dictionaries")
+	}
+	pw, err := NewParallelFrameWriter(w, opts)
+	if err != nil {
+		return nil, err
+	}
+	sw := &SeekableWriter{
+		ParallelFrameWriter: pw,
+		underlyingWriter:    w,
+	}
+	pw.onBlock = sw.addBlock
+	return sw, nil
+}
+
+// addBlock adds a block to the seek table.
+func (w *SeekableWriter) addBlock(encoded, uncompressed int) {
+	var entry [8]byte
+	binary.LittleEndian.PutUint32(entry[:], uint32(encoded))
+	binary.LittleEndian.PutUint32(entry[4:], uint32(uncompressed))
+	w.table = append(w.table, entry[:]...)
+	w.blocks++
+}
+
+// Close flushes any buffered data, writes the end of the frame followed by
+// the seek table, and stops the goroutines of w.  It does not close the
+// underlying io.Writer.
+func (w *SeekableWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	if err := w.ParallelFrameWriter.Close(); err != nil {
+		return err
+	}
+	if len(w.table)+8 > math.MaxUint32 {
+		return fmt.Errorf("lz4: seek table is too large: %d blocks", w.blocks)
+	}
+	var footer [8]byte
+	binary.LittleEndian.PutUint32(footer[:], uint32(w.blocks))
+	binary.LittleEndian.PutUint32(footer[4:], seekableMagic)
+	return WriteSkippableFrame(w.underlyingWriter, seekableFrameID, append(w.table, footer[:]...))
+}
+
+// SeekableReader reads a seekable stream written by SeekableWriter, and only
+// decompresses the blocks it needs.  ReadAt is safe for concurrent use, but
+// Read and Seek are not.
+type SeekableReader struct {
+	underlyingReader io.ReaderAt
+	blockChecksum    bool
+	// offsets holds the uncompressed offset of every block, and
+	// encodedOffsets their offset in the stream, followed by the end of the
+	// last block.
+	offsets        []int64
+	encodedOffsets []int64
+	// pos is the offset of the next Read.
+	pos int64
+
+	// mu guards the last decompressed block, which is kept in case the next
+	// read needs it too.
+	mu          sync.Mutex
+	cachedIndex int
+	cached      []byte
+}
+
+// NewSeekableReader creates a new SeekableReader that reads the seekable
+// stream of size bytes in r.  It reads the seek table and the frame header,
+// and fails with ErrCorrupt if they are missing or malformed.
+func NewSeekableReader(r io.ReaderAt, size int64) (*SeekableReader, error) {
+	var footer [8]byte
+	if size < int64(len(footer)) {
+		return nil, fmt.Errorf("%w: stream is too short to be seekable", ErrCorrupt)
+	}
+	if err := readFullAt(r, footer[:], size-int64(len(footer))); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(footer[4:]) != seekableMagic {
+		return nil, fmt.Errorf("%w: stream has no seek table", ErrCorrupt)
+	}
+	blocks := int64(binary.LittleEndian.Uint32(footer[:]))
+	tableSize := 8 + 8*blocks + int64(len(footer))
+	if tableSize > size {
+		return nil, fmt.Errorf("%w: seek table is larger than the stream", ErrCorrupt)
+	}
+	table := make([]byte, tableSize)
+	if err := readFullAt(r, table, size-tableSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(table) != skippableFrameMagic|seekableFrameID ||
+		int64(binary.LittleEndian.Uint32(table[4:])) != tableSize-8 {
+		return nil, fmt.Errorf("%w: malformed seek table", ErrCorrupt)
+	}
+
+	header := io.NewSectionReader(r, 0, size-tableSize)
+	info, err := ReadFrameInfo(header)
+	if err != nil {
+		return nil, err
+	}
+	if info.BlockMode != BlockIndependent {
+		return nil, fmt.Errorf("%w: seekable stream has linked blocks", ErrCorrupt)
+	}
+	if info.DictID != 0 {
+		return nil, errors.New("lz4: seekable streams do not support dictionaries")
+	}
+	headerSize, _ := header.Seek(0, io.SeekCurrent)
+
+	sr := &SeekableReader{
+		underlyingReader: r,
+		blockChecksum:    info.BlockChecksum,
+		offsets:          make([]int64, blocks+1),
+		encodedOffsets:   make([]int64, blocks+1),
+		cachedIndex:      -1,
+	}
+	sr.encodedOffsets[0] = headerSize
+	minEncoded := int64(4)
+	if info.BlockChecksum {
+		minEncoded += 4
+	}
+	for i := int64(0); i < blocks; i++ {
+		entry := table[8+8*i:]
+		encoded := int64(binary.LittleEndian.Uint32(entry))
+		uncompressed := int64(binary.LittleEndian.Uint32(entry[4:]))
+		if encoded <= minEncoded || encoded > minEncoded+int64(info.BlockSize.bytes()) ||
+			uncompressed == 0 || uncompressed > int64(info.BlockSize.bytes()) {
+			return nil, fmt.Errorf("%w: malformed seek table entry %d", ErrCorrupt, i)
+		}
+		sr.encodedOffsets[i+1] = sr.encodedOffsets[i] + encoded
+		sr.offsets[i+1] = sr.offsets[i] + uncompressed
+	}
+
+	// The blocks are followed by the end mark and the content checksum.
+	end := sr.encodedOffsets[blocks] + 4
+	if info.ContentChecksum {
+		end += 4
+	}
+	if end != size-tableSize {
+		return nil, fmt.Errorf("%w: seek table does not match the frame", ErrCorrupt)
+	}
+	return sr, nil
+}
+
+// readFullAt reads len(b) bytes at off from r.
+func readFullAt(r io.ReaderAt, b []byte, off int64) error {
+	n, err := r.ReadAt(b, off)
+	if n == len(b) {
+		return nil
+	}
+	return unexpectedEOF(err)
+}
+
+// Size returns the uncompressed size of the stream.
+func (r *SeekableReader) Size() int64 {
+	return r.offsets[len(r.offsets)-1]
+}
+
+// ReadAt decompresses len(p) bytes at offset off of the uncompressed stream
+// into p.  It implements io.ReaderAt.
+func (r *SeekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("lz4: negative offset")
+	}
+	blocks := len(r.offsets) - 1
+	i := sort.Search(blocks, func(i int) bool {
+		return r.offsets[i+1] > off
+	})
+
+	n := 0
+	for ; n < len(p) && i < blocks; i++ {
+		data, err := r.block(i)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[off+int64(n)-r.offsets[i]:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block returns the decompressed data of the block at index i.
+func (r *SeekableReader) block(i int) ([]byte, error) {
+	r.mu.Lock()
+	if r.cachedIndex == i {
+		data := r.cached
+		r.mu.Unlock()
+		return data, nil
+	}
+	r.mu.Unlock()
+
+	encoded := make([]byte, r.encodedOffsets[i+1]-r.encodedOffsets[i])
+	if err := readFullAt(r.underlyingReader, encoded, r.encodedOffsets[i]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(encoded)
+	src := encoded[4:]
+	var checksum uint32
+	if r.blockChecksum {
+		checksum = binary.LittleEndian.Uint32(src[len(src)-4:])
+		src = src[:len(src)-4]
+	}
+	if int(size&^uncompressedBlockFlag) != len(src) {
+		return nil, fmt.Errorf("%w: block %d does not match the seek table", ErrCorrupt, i)
+	}
+	data := make([]byte, r.offsets[i+1]-r.offsets[i])
+	n, err := decodeFrameBlock(data, src, size&uncompressedBlockFlag != 0, r.blockChecksum, checksum, nil)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(data) {
+		return nil, fmt.Errorf("%w: block %d does not match the seek table", ErrCorrupt, i)
+	}
+
+	// data is never written to again, so it can be shared.
+	r.mu.Lock()
+	r.cachedIndex, r.cached = i, data
+	r.mu.Unlock()
+	return data, nil
+}
+
+// Read decompresses data from the current offset into p.  It returns io.EOF
+// at the end of the stream.
+func (r *SeekableReader) Read(p []byte) (int, error) {
+	if r.pos >= r.Size() {
+		return 0, io.EOF
+	}
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read in the uncompressed stream.  It
+// implements io.Seeker.
+func (r *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.Size()
+	default:
+		return 0, fmt.Errorf("lz4: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("lz4: negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
@@ -0,0 +1,179 @@
+package lz4
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func compressSeekable(t *testing.T, input []byte, opts ParallelOptions) []byte {
+	var buf bytes.Buffer
+	w, err := NewSeekableWriter(&buf, opts)
+	failOnError(t, "Failed creating seekable writer", err)
+	// Flush in the middle, so that not all blocks are full.
+	_, err = w.Write(input[:len(input)/3])
+	failOnError(t, "Failed writing to seekable writer", err)
+	failOnError(t, "Failed flushing seekable writer", w.Flush())
+	_, err = w.Write(input[len(input)/3:])
+	failOnError(t, "Failed writing to seekable writer", err)
+	failOnError(t, "Failed closing seekable writer", w.Close())
+	failOnError(t, "Failed closing seekable writer twice", w.Close())
+	return buf.Bytes()
+}
+
+func TestSeekable(t *testing.T) {
+	input := parallelInput(3 << 20)
+	for _, opts := range []ParallelOptions{
+		{},
+		{FrameOptions: FrameOptions{BlockSize: BlockSize256KB, BlockChecksum: true, ContentChecksum: true, CompressionLevel: 9}},
+	} {
+		compressed := compressSeekable(t, input, opts)
+
+		// The stream is a regular frame.
+		fr := NewFrameReader(bytes.NewReader(compressed))
+		output, err := ioutil.ReadAll(fr)
+		fr.Close()
+		failOnError(t, "Failed to decompress as a frame", err)
+		if !bytes.Equal(input, output) {
+			t.Fatalf("Decompressed frame != input")
+		}
+
+		r, err := NewSeekableReader(bytes.NewReader(compressed), int64(len(compressed)))
+		failOnError(t, "Failed opening seekable reader", err)
+		if r.Size() != int64(len(input)) {
+			t.Fatalf("Size should be %d, was %d", len(input), r.Size())
+		}
+		output, err = ioutil.ReadAll(r)
+		failOnError(t, "Failed to read seekable stream", err)
+		if !bytes.Equal(input, output) {
+			t.Fatalf("Seekable stream != input")
+		}
+
+		rnd := rand.New(rand.NewSource(2))
+		for i := 0; i < 200; i++ {
+			off := rnd.Int63n(int64(len(input)))
+			p := make([]byte, rnd.Intn(300000))
+			n, err := r.ReadAt(p, off)
+			want := input[off:]
+			if len(want) > len(p) {
+				want = want[:len(p)]
+				failOnError(t, "Failed to read at offset", err)
+			} else if err != io.EOF {
+				t.Fatalf("ReadAt past the end should have returned io.EOF, got %v", err)
+			}
+			if !bytes.Equal(p[:n], want) {
+				t.Fatalf("ReadAt(%d bytes, %d) returned the wrong data", len(p), off)
+			}
+		}
+	}
+}
+
+func TestSeekableSeek(t *testing.T) {
+	input := parallelInput(1 << 20)
+	compressed := compressSeekable(t, input, ParallelOptions{})
+	r, err := NewSeekableReader(bytes.NewReader(compressed), int64(len(compressed)))
+	failOnError(t, "Failed opening seekable reader", err)
+
+	for _, seek := range []struct {
+		offset int64
+		whence int
+		want   int64
+	}{
+		{100000, io.SeekStart, 100000},
+		{-5000, io.SeekCurrent, 95100},
+		{-10, io.SeekEnd, int64(len(input)) - 10},
+		{70000, io.SeekStart, 70000},
+	} {
+		pos, err := r.Seek(seek.offset, seek.whence)
+		failOnError(t, "Failed to seek", err)
+		if pos != seek.want {
+			t.Fatalf("Seek(%d, %d) should have gone to %d, went to %d", seek.offset, seek.whence, seek.want, pos)
+		}
+		p := make([]byte, 100)
+		n, err := io.ReadFull(r, p)
+		if !bytes.Equal(p[:n], input[pos:pos+int64(n)]) || (n < len(p) && err != io.ErrUnexpectedEOF) {
+			t.Fatalf("Reading at %d returned the wrong data, %v", pos, err)
+		}
+	}
+
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("Seeking before the start should have failed")
+	}
+	if _, err := r.Seek(10, io.SeekEnd); err != nil {
+		t.Errorf("Seeking past the end should have worked: %v", err)
+	}
+	if n, err := r.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("Reading past the end should have returned io.EOF, got %d, %v", n, err)
+	}
+}
+
+func TestSeekableConcurrentReadAt(t *testing.T) {
+	input := parallelInput(2 << 20)
+	compressed := compressSeekable(t, input, ParallelOptions{})
+	r, err := NewSeekableReader(bytes.NewReader(compressed), int64(len(compressed)))
+	failOnError(t, "Failed opening seekable reader", err)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			p := make([]byte, 1000)
+			for i := 0; i < 100; i++ {
+				off := rnd.Int63n(int64(len(input) - len(p)))
+				if _, err := r.ReadAt(p, off); err != nil || !bytes.Equal(p, input[off:off+int64(len(p))]) {
+					t.Errorf("ReadAt(%d) failed: %v", off, err)
+					return
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+}
+
+func TestSeekableErrors(t *testing.T) {
+	input := parallelInput(1 << 20)
+	compressed := compressSeekable(t, input, ParallelOptions{FrameOptions: FrameOptions{BlockChecksum: true}})
+
+	var plain bytes.Buffer
+	w := NewFrameWriter(&plain)
+	_, err := w.Write(input)
+	failOnError(t, "Failed writing to frame writer", err)
+	failOnError(t, "Failed closing frame writer", w.Close())
+
+	corrupt := func(pos int) []byte {
+		b := append([]byte(nil), compressed...)
+		b[pos] ^= 0x55
+		return b
+	}
+	for _, test := range []struct {
+		name       string
+		compressed []byte
+	}{
+		{"empty", nil},
+		{"not seekable", plain.Bytes()},
+		{"truncated", compressed[:len(compressed)-1]},
+		{"block count", corrupt(len(compressed) - 8)},
+		{"table entry", corrupt(len(compressed) - 20)},
+		{"header", corrupt(4)},
+	} {
+		if _, err := NewSeekableReader(bytes.NewReader(test.compressed), int64(len(test.compressed))); err == nil {
+			t.Errorf("%s: opening the seekable stream should have failed", test.name)
+		}
+	}
+
+	r, err := NewSeekableReader(bytes.NewReader(corrupt(100)), int64(len(compressed)))
+	failOnError(t, "Failed opening seekable reader", err)
+	if _, err := r.ReadAt(make([]byte, 10), 0); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Reading a corrupt block should have failed with ErrChecksum, got %v", err)
+	}
+
+	if _, err := NewSeekableWriter(ioutil.Discard, ParallelOptions{FrameOptions: FrameOptions{Dictionary: jsonDictionary(), DictID: 1}}); err == nil {
+		t.Errorf("Seekable streams with a dictionary should have been rejected")
+	}
+}